@@ -9,6 +9,7 @@ kubectl delete -f artifacts/example/auth-delegator.yaml -n kube-system
 kubectl delete -f artifacts/example/auth-reader.yaml -n kube-system
 kubectl delete -f artifacts/example/apiservice.yaml
 kubectl delete -f artifacts/example/grant-cluster-admin.yaml
+kubectl delete -f artifacts/example/kindcomposition-crd.yaml
 
 #kubectl create ns wardle
 #kubectl create configmap -n wardle kind-compositions-config-map --from-file=kind_compositions.yaml
@@ -7,6 +7,7 @@
 
 kubectl create -f artifacts/example/ns.yaml
 kubectl create configmap -n discovery kind-compositions-config-map --from-file=kind_compositions.yaml
+kubectl create -f artifacts/example/kindcomposition-crd.yaml
 
 
 kubectl create -f artifacts/example/sa.yaml -n discovery
@@ -18,7 +18,10 @@ func main() {
 	stopCh := genericapiserver.SetupSignalHandler()
 	options := server.NewDiscoveryServerOptions(os.Stdout, os.Stderr)
 	cmd := server.NewCommandStartDiscoveryServer(options, stopCh)
+	cmd.AddCommand(server.NewCommandValidateConfig(os.Stdout))
 	cmd.Flags().AddGoFlagSet(flag.CommandLine)
+	// The Go flags (e.g. of glog) are parsed by cobra; mark them as parsed
+	flag.CommandLine.Parse([]string{})
 	if err := cmd.Execute(); err != nil {
 		glog.Fatal(err)
 	}
@@ -2,6 +2,8 @@ package apiserver
 
 import (
 	"fmt"
+	"net/http"
+	"strconv"
 	"strings"
 
 	"encoding/json"
@@ -12,6 +14,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 	"k8s.io/apimachinery/pkg/version"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
 	genericapiserver "k8s.io/apiserver/pkg/server"
 
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
@@ -24,6 +27,9 @@ const GroupVersion = "v1"
 const KIND_QUERY_PARAM = "kind"
 const INSTANCE_QUERY_PARAM = "instance"
 const NAMESPACE_QUERY_PARAM = "namespace"
+const LABELS_QUERY_PARAM = "labels"
+const ANNOTATIONS_QUERY_PARAM = "annotations"
+const EVENTS_QUERY_PARAM = "events"
 
 var (
 	Scheme             = runtime.NewScheme()
@@ -61,8 +67,6 @@ func init() {
 		&metav1.APIGroup{},
 		&metav1.APIResourceList{},
 	)
-	// Start collecting provenance
-	go discovery.BuildCompositionTree()
 }
 
 type ExtraConfig struct {
@@ -173,6 +177,15 @@ func installExplainDescribePaths(discoveryServer *DiscoveryServer) {
 	//	Consumes(restful.MIME_JSON, restful.MIME_XML).
 	//	Produces(restful.MIME_JSON, restful.MIME_XML)
 	ws1.Route(ws1.GET("/composition").To(handleComposition))
+
+	// Manifests can be posted as JSON or YAML
+	ws1.Route(ws1.POST("/validate").Consumes("*/*").To(handleValidate))
+
+	ws1.Route(ws1.GET("/syncstatus").To(handleSyncStatus))
+
+	ws1.Route(ws1.GET("/images").To(handleImages))
+
+	ws1.Route(ws1.GET("/kinds").To(handleKinds))
 	discoveryServer.GenericAPIServer.Handler.GoRestfulContainer.Add(ws1)
 }
 
@@ -184,10 +197,11 @@ func handleExplain(request *restful.Request, response *restful.Response) {
 	//fmt.Printf("Query Kind:%s\n", queryKind)
 	openAPISpec := discovery.GetOpenAPISpec(customResourceKind)
 	//fmt.Println("OpenAPI Spec:%v", openAPISpec)
+	definitionPrefix := discovery.GetOpenAPISpecLocation(customResourceKind).DefinitionPrefix
 
 	queryResponse := ""
 	if openAPISpec != "" {
-		queryResponse = parseOpenAPISpec([]byte(openAPISpec), queryKind)
+		queryResponse = parseOpenAPISpec([]byte(openAPISpec), definitionPrefix, queryKind)
 		//fmt.Printf("Query response:%s\n", queryResponse)
 	}
 
@@ -219,14 +233,110 @@ func handleComposition(request *restful.Request, response *restful.Response) {
 	if namespace == "" {
 		namespace = "default"
 	}
-	describeInfo := discovery.TotalClusterCompositions.GetCompositions(resourceKind, resourceInstance, namespace)
+	accessChecker, err := getAccessChecker(request)
+	if err != nil {
+		response.WriteErrorString(http.StatusUnauthorized, err.Error())
+		return
+	}
+	describeInfo, err := discovery.TotalClusterCompositions.GetCompositions(resourceKind, resourceInstance, namespace,
+		accessChecker, getCompositionOptions(request))
+	if err != nil {
+		fmt.Printf("Error:%s\n", err.Error())
+		response.WriteErrorString(http.StatusServiceUnavailable, err.Error())
+		return
+	}
 	fmt.Printf("Composition:%v\n", describeInfo)
 
 	response.Write([]byte(describeInfo))
 }
 
+// handleImages returns the images run by the Pods in the composition trees of the
+// requested instance(s), grouped by image.
+func handleImages(request *restful.Request, response *restful.Response) {
+	resourceKind := request.QueryParameter(KIND_QUERY_PARAM)
+	resourceInstance := request.QueryParameter(INSTANCE_QUERY_PARAM)
+	namespace := request.QueryParameter(NAMESPACE_QUERY_PARAM)
+
+	fmt.Printf("Kind:%s, Instance:%s\n", resourceKind, resourceInstance)
+	if namespace == "" {
+		namespace = "default"
+	}
+	accessChecker, err := getAccessChecker(request)
+	if err != nil {
+		response.WriteErrorString(http.StatusUnauthorized, err.Error())
+		return
+	}
+	images, err := discovery.TotalClusterCompositions.GetImages(resourceKind, resourceInstance, namespace, accessChecker)
+	if err != nil {
+		fmt.Printf("Error:%s\n", err.Error())
+		response.WriteErrorString(http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	response.Write([]byte(images))
+}
+
+// handleKinds returns the entries of the Kind registry along with the layer
+// (built-in, file, CRD annotation or KindComposition) each of them came from.
+func handleKinds(request *restful.Request, response *restful.Response) {
+	kinds, err := discovery.GetKinds()
+	if err != nil {
+		fmt.Printf("Error:%s\n", err.Error())
+		response.WriteErrorString(http.StatusInternalServerError, err.Error())
+		return
+	}
+	response.Write([]byte(kinds))
+}
+
+// handleSyncStatus reports whether the composition trees are up to date. While the
+// main API server is unavailable the last good trees are served and marked as Stale.
+func handleSyncStatus(request *restful.Request, response *restful.Response) {
+	syncStatus, err := discovery.GetSyncStatus()
+	if err != nil {
+		fmt.Printf("Error:%s\n", err.Error())
+		response.WriteErrorString(http.StatusInternalServerError, err.Error())
+		return
+	}
+	response.Write([]byte(syncStatus))
+}
+
+// getCompositionOptions reads the 'labels' and 'annotations' query parameters, which are
+// comma separated lists of globs selecting the label and annotation keys to return,
+// and the 'events' query parameter.
+func getCompositionOptions(request *restful.Request) discovery.CompositionOptions {
+	events, _ := strconv.ParseBool(request.QueryParameter(EVENTS_QUERY_PARAM))
+	return discovery.CompositionOptions{
+		Labels:      splitQueryParameter(request.QueryParameter(LABELS_QUERY_PARAM)),
+		Annotations: splitQueryParameter(request.QueryParameter(ANNOTATIONS_QUERY_PARAM)),
+		Events:      events,
+	}
+}
+
+func splitQueryParameter(value string) []string {
+	values := []string{}
+	for _, elem := range strings.Split(value, ",") {
+		if elem = strings.TrimSpace(elem); elem != "" {
+			values = append(values, elem)
+		}
+	}
+	return values
+}
+
+// getAccessChecker returns an AccessChecker for the user making the request.
+// Composition trees are built with kubediscovery's own (cluster-wide) credentials,
+// so every node is checked against what the caller is allowed to get.
+func getAccessChecker(request *restful.Request) (discovery.AccessChecker, error) {
+	caller, ok := genericapirequest.UserFrom(request.Request.Context())
+	if !ok {
+		return nil, fmt.Errorf("No user found in request")
+	}
+	return discovery.NewSubjectAccessChecker(caller.GetName(), caller.GetUID(), caller.GetGroups(), caller.GetExtra()), nil
+}
+
+// installCompositionWebService registers a path per Kind in the Kind registry at startup.
+// Kinds added to the registry later (by CRD annotations or KindComposition objects) get no
+// path and are only served through the /composition endpoint.
 func installCompositionWebService(discoveryServer *DiscoveryServer) {
-	for _, resourceKindPlural := range discovery.KindPluralMap {
+	for _, resourceKindPlural := range discovery.GetKindPlurals() {
 		namespaceToUse := discovery.Namespace
 		path := "/apis/" + GroupName + "/" + GroupVersion + "/namespaces/"
 		path = path + namespaceToUse + "/" + strings.ToLower(resourceKindPlural)
@@ -264,7 +374,18 @@ func getCompositions(request *restful.Request, response *restful.Response) {
 	resourceNamespace := resourcePathSlice[5]
 	fmt.Printf("Resource Kind:%s, Resource name:%s\n", resourceKind, resourceName)
 
-	compositionsInfo := discovery.TotalClusterCompositions.GetCompositions(resourceKind, resourceName, resourceNamespace)
+	accessChecker, err := getAccessChecker(request)
+	if err != nil {
+		response.WriteErrorString(http.StatusUnauthorized, err.Error())
+		return
+	}
+	compositionsInfo, err := discovery.TotalClusterCompositions.GetCompositions(resourceKind, resourceName, resourceNamespace,
+		accessChecker, getCompositionOptions(request))
+	if err != nil {
+		fmt.Printf("Error:%s\n", err.Error())
+		response.WriteErrorString(http.StatusServiceUnavailable, err.Error())
+		return
+	}
 	fmt.Printf("Compositions Info:%v", compositionsInfo)
 
 	response.Write([]byte(compositionsInfo))
@@ -285,7 +406,7 @@ func getQueryKind(input string) (string, string) {
 	return customResourceKind, queryKind
 }
 
-func parseOpenAPISpec(openAPISpec []byte, customResourceKind string) string {
+func parseOpenAPISpec(openAPISpec []byte, definitionPrefix, customResourceKind string) string {
 	var data interface{}
 	retVal := ""
 	err := json.Unmarshal(openAPISpec, &data)
@@ -297,7 +418,7 @@ func parseOpenAPISpec(openAPISpec []byte, customResourceKind string) string {
 
 	definitionsMap := overallMap["definitions"].(map[string]interface{})
 
-	queryString := "typedir." + customResourceKind
+	queryString := definitionPrefix + customResourceKind
 	resultMap := definitionsMap[queryString]
 
 	result, err1 := json.Marshal(resultMap)
@@ -0,0 +1,291 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/emicklei/go-restful"
+	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
+
+	"github.com/cloud-ark/kubediscovery/pkg/discovery"
+)
+
+// Used for reporting a single problem found in a manifest
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+// Used for Final output of the /validate endpoint
+type ValidationResult struct {
+	Kind   string
+	Name   string
+	Valid  bool
+	Errors []ValidationError
+}
+
+// Fields that are part of every Kubernetes object. These are validated by the
+// main API server and are typically not part of the generated OpenAPI Spec.
+var objectFields = []string{"apiVersion", "kind", "metadata", "status"}
+
+func handleValidate(request *restful.Request, response *restful.Response) {
+	content, err := ioutil.ReadAll(request.Request.Body)
+	if err != nil {
+		response.WriteErrorString(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Manifests can be posted either as YAML or as JSON
+	jsonContent, err := k8syaml.ToJSON(content)
+	if err != nil {
+		response.WriteErrorString(http.StatusBadRequest, "Manifest could not be parsed: "+err.Error())
+		return
+	}
+
+	var manifest map[string]interface{}
+	if err := json.Unmarshal(jsonContent, &manifest); err != nil || manifest == nil {
+		response.WriteErrorString(http.StatusBadRequest, "Manifest is not a Kubernetes object")
+		return
+	}
+
+	customResourceKind, _ := manifest["kind"].(string)
+	if customResourceKind == "" {
+		response.WriteErrorString(http.StatusBadRequest, "Manifest does not specify kind")
+		return
+	}
+	fmt.Printf("Validating manifest of Kind:%s\n", customResourceKind)
+
+	openAPISpec := discovery.GetOpenAPISpec(customResourceKind)
+	if openAPISpec == "" {
+		response.WriteErrorString(http.StatusNotFound, "No OpenAPI Spec registered for Kind "+customResourceKind)
+		return
+	}
+
+	definitionPrefix := discovery.GetOpenAPISpecLocation(customResourceKind).DefinitionPrefix
+	result, err := validateManifest([]byte(openAPISpec), definitionPrefix, customResourceKind, manifest)
+	if err != nil {
+		response.WriteErrorString(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	resultBytes, err := json.Marshal(result)
+	if err != nil {
+		response.WriteErrorString(http.StatusInternalServerError, err.Error())
+		return
+	}
+	response.Write(resultBytes)
+}
+
+func validateManifest(openAPISpec []byte, definitionPrefix, customResourceKind string,
+	manifest map[string]interface{}) (ValidationResult, error) {
+	result := ValidationResult{
+		Kind:   customResourceKind,
+		Errors: []ValidationError{},
+	}
+	if metadata, ok := manifest["metadata"].(map[string]interface{}); ok {
+		result.Name, _ = metadata["name"].(string)
+	}
+
+	var data interface{}
+	if err := json.Unmarshal(openAPISpec, &data); err != nil {
+		return result, fmt.Errorf("OpenAPI Spec for Kind %s could not be parsed: %s", customResourceKind, err.Error())
+	}
+	overallMap, _ := data.(map[string]interface{})
+	definitionsMap, ok := overallMap["definitions"].(map[string]interface{})
+	if !ok {
+		return result, fmt.Errorf("OpenAPI Spec for Kind %s has no definitions", customResourceKind)
+	}
+
+	schema, ok := definitionsMap[definitionPrefix+customResourceKind].(map[string]interface{})
+	if !ok {
+		return result, fmt.Errorf("OpenAPI Spec does not define Kind %s", customResourceKind)
+	}
+
+	v := validator{definitions: definitionsMap}
+	v.validateObject("", schema, manifest, true)
+
+	result.Errors = append(result.Errors, v.errors...)
+	result.Valid = len(v.errors) == 0
+	return result, nil
+}
+
+type validator struct {
+	definitions map[string]interface{}
+	errors      []ValidationError
+}
+
+func (v *validator) addError(field, format string, args ...interface{}) {
+	if field == "" {
+		field = "."
+	}
+	v.errors = append(v.errors, ValidationError{
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// resolve follows $ref entries of the form '#/definitions/<name>'.
+// It returns nil if the reference points outside of the registered definitions,
+// in which case the corresponding part of the manifest is not validated.
+func (v *validator) resolve(schema map[string]interface{}) map[string]interface{} {
+	for i := 0; i < 10; i++ {
+		ref, ok := schema["$ref"].(string)
+		if !ok {
+			return schema
+		}
+		name := strings.TrimPrefix(ref, "#/definitions/")
+		resolved, ok := v.definitions[name].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		schema = resolved
+	}
+	return nil
+}
+
+func (v *validator) validate(field string, schema map[string]interface{}, value interface{}) {
+	schema = v.resolve(schema)
+	if schema == nil || value == nil {
+		return
+	}
+
+	schemaType, _ := schema["type"].(string)
+	if schemaType == "" {
+		if _, ok := schema["properties"]; ok {
+			schemaType = "object"
+		}
+	}
+
+	switch schemaType {
+	case "object":
+		valueMap, ok := value.(map[string]interface{})
+		if !ok {
+			v.addError(field, "expected object, got %s", jsonType(value))
+			return
+		}
+		v.validateObject(field, schema, valueMap, false)
+	case "array":
+		valueList, ok := value.([]interface{})
+		if !ok {
+			v.addError(field, "expected array, got %s", jsonType(value))
+			return
+		}
+		items, _ := schema["items"].(map[string]interface{})
+		if items == nil {
+			return
+		}
+		for i, item := range valueList {
+			v.validate(fmt.Sprintf("%s[%d]", field, i), items, item)
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			v.addError(field, "expected string, got %s", jsonType(value))
+		}
+	case "integer":
+		number, ok := value.(float64)
+		if !ok || number != float64(int64(number)) {
+			v.addError(field, "expected integer, got %s", jsonType(value))
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			v.addError(field, "expected number, got %s", jsonType(value))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			v.addError(field, "expected boolean, got %s", jsonType(value))
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok && len(enum) > 0 {
+		allowed := false
+		for _, e := range enum {
+			if fmt.Sprintf("%v", e) == fmt.Sprintf("%v", value) {
+				allowed = true
+			}
+		}
+		if !allowed {
+			v.addError(field, "unsupported value %v, allowed values are %v", value, enum)
+		}
+	}
+}
+
+func (v *validator) validateObject(field string, schema map[string]interface{}, value map[string]interface{}, topLevel bool) {
+	properties, _ := schema["properties"].(map[string]interface{})
+
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, r := range required {
+			name, _ := r.(string)
+			if _, present := value[name]; !present {
+				v.addError(joinField(field, name), "required field is missing")
+			}
+		}
+	}
+
+	// Report fields in a stable order so that CI output is reproducible
+	keys := make([]string, 0, len(value))
+	for key := range value {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		childField := joinField(field, key)
+		if propertySchema, ok := properties[key].(map[string]interface{}); ok {
+			v.validate(childField, propertySchema, value[key])
+			continue
+		}
+		if topLevel && isObjectField(key) {
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case map[string]interface{}:
+			v.validate(childField, additional, value[key])
+		case bool:
+			if !additional {
+				v.addError(childField, "unknown field")
+			}
+		default:
+			// Only flag unknown fields when the schema actually lists the
+			// allowed ones; otherwise we do not know enough to judge.
+			if properties != nil {
+				v.addError(childField, "unknown field")
+			}
+		}
+	}
+}
+
+func isObjectField(key string) bool {
+	for _, f := range objectFields {
+		if f == key {
+			return true
+		}
+	}
+	return false
+}
+
+func joinField(parent, child string) string {
+	if parent == "" {
+		return child
+	}
+	return parent + "." + child
+}
+
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return "null"
+}
@@ -0,0 +1,201 @@
+package apiserver
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testOpenAPISpec = `{
+  "definitions": {
+    "typesMysqlCluster": {
+      "required": ["spec"],
+      "properties": {
+        "spec": {"$ref": "#/definitions/typesMysqlClusterSpec"}
+      }
+    },
+    "typesMysqlClusterSpec": {
+      "required": ["replicas"],
+      "properties": {
+        "replicas": {"type": "integer"},
+        "version": {"type": "string", "enum": ["5.6", "5.7"]},
+        "backup": {"$ref": "#/definitions/typesBackup"},
+        "volumes": {"type": "array", "items": {"$ref": "#/definitions/typesVolume"}},
+        "labels": {"type": "object", "additionalProperties": {"type": "string"}},
+        "external": {"$ref": "#/definitions/io.k8s.api.core.v1.PodSpec"}
+      }
+    },
+    "typesBackup": {
+      "properties": {
+        "enabled": {"type": "boolean"},
+        "schedule": {"type": "string"}
+      }
+    },
+    "typesVolume": {
+      "required": ["name"],
+      "properties": {
+        "name": {"type": "string"},
+        "size": {"type": "number"}
+      },
+      "additionalProperties": false
+    }
+  }
+}`
+
+func TestValidateManifest(t *testing.T) {
+	testCases := []struct {
+		name     string
+		spec     map[string]interface{}
+		expected []ValidationError
+	}{
+		{
+			name:     "valid",
+			spec:     map[string]interface{}{"replicas": float64(3), "version": "5.7"},
+			expected: []ValidationError{},
+		},
+		{
+			name:     "required field missing",
+			spec:     map[string]interface{}{"version": "5.7"},
+			expected: []ValidationError{{"spec.replicas", "required field is missing"}},
+		},
+		{
+			name:     "wrong type",
+			spec:     map[string]interface{}{"replicas": "three"},
+			expected: []ValidationError{{"spec.replicas", "expected integer, got string"}},
+		},
+		{
+			name:     "fractional integer",
+			spec:     map[string]interface{}{"replicas": float64(1.5)},
+			expected: []ValidationError{{"spec.replicas", "expected integer, got number"}},
+		},
+		{
+			name:     "value not in enum",
+			spec:     map[string]interface{}{"replicas": float64(1), "version": "8.0"},
+			expected: []ValidationError{{"spec.version", "unsupported value 8.0, allowed values are [5.6 5.7]"}},
+		},
+		{
+			name:     "unknown field",
+			spec:     map[string]interface{}{"replicas": float64(1), "replica": float64(1)},
+			expected: []ValidationError{{"spec.replica", "unknown field"}},
+		},
+		{
+			name: "unknown field in $ref definition",
+			spec: map[string]interface{}{"replicas": float64(1),
+				"backup": map[string]interface{}{"enabled": true, "retention": "7d"}},
+			expected: []ValidationError{{"spec.backup.retention", "unknown field"}},
+		},
+		{
+			name: "wrong type in $ref definition",
+			spec: map[string]interface{}{"replicas": float64(1),
+				"backup": map[string]interface{}{"enabled": "yes"}},
+			expected: []ValidationError{{"spec.backup.enabled", "expected boolean, got string"}},
+		},
+		{
+			name: "$ref definition of array items",
+			spec: map[string]interface{}{"replicas": float64(1),
+				"volumes": []interface{}{
+					map[string]interface{}{"name": "data", "size": float64(10)},
+					map[string]interface{}{"size": "10Gi", "class": "ssd"},
+				}},
+			expected: []ValidationError{
+				{"spec.volumes[1].name", "required field is missing"},
+				{"spec.volumes[1].class", "unknown field"},
+				{"spec.volumes[1].size", "expected number, got string"},
+			},
+		},
+		{
+			name:     "$ref outside of the definitions is not validated",
+			spec:     map[string]interface{}{"replicas": float64(1), "external": map[string]interface{}{"anything": true}},
+			expected: []ValidationError{},
+		},
+		{
+			name:     "additionalProperties schema",
+			spec:     map[string]interface{}{"replicas": float64(1), "labels": map[string]interface{}{"tier": float64(1)}},
+			expected: []ValidationError{{"spec.labels.tier", "expected string, got number"}},
+		},
+		{
+			name:     "object expected",
+			spec:     map[string]interface{}{"replicas": float64(1), "backup": "daily"},
+			expected: []ValidationError{{"spec.backup", "expected object, got string"}},
+		},
+	}
+
+	for _, testCase := range testCases {
+		manifest := map[string]interface{}{
+			"apiVersion": "mysql.example.com/v1",
+			"kind":       "MysqlCluster",
+			"metadata":   map[string]interface{}{"name": "cluster1"},
+			"spec":       testCase.spec,
+		}
+		result, err := validateManifest([]byte(testOpenAPISpec), "types", "MysqlCluster", manifest)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", testCase.name, err.Error())
+			continue
+		}
+		if result.Name != "cluster1" || result.Kind != "MysqlCluster" {
+			t.Errorf("%s: got Kind %s and name %s", testCase.name, result.Kind, result.Name)
+		}
+		if !reflect.DeepEqual(result.Errors, testCase.expected) {
+			t.Errorf("%s: expected errors %v, got %v", testCase.name, testCase.expected, result.Errors)
+		}
+		if result.Valid != (len(testCase.expected) == 0) {
+			t.Errorf("%s: expected Valid to be %t", testCase.name, len(testCase.expected) == 0)
+		}
+	}
+}
+
+func TestValidateManifestTopLevel(t *testing.T) {
+	testCases := []struct {
+		name     string
+		manifest map[string]interface{}
+		expected []ValidationError
+	}{
+		{
+			name:     "required spec missing",
+			manifest: map[string]interface{}{"kind": "MysqlCluster"},
+			expected: []ValidationError{{"spec", "required field is missing"}},
+		},
+		{
+			name: "object fields are not flagged",
+			manifest: map[string]interface{}{"apiVersion": "mysql.example.com/v1", "kind": "MysqlCluster",
+				"metadata": map[string]interface{}{}, "status": map[string]interface{}{},
+				"spec": map[string]interface{}{"replicas": float64(1)}},
+			expected: []ValidationError{},
+		},
+		{
+			name: "unknown top level field",
+			manifest: map[string]interface{}{"kind": "MysqlCluster",
+				"spec": map[string]interface{}{"replicas": float64(1)}, "sepc": map[string]interface{}{}},
+			expected: []ValidationError{{"sepc", "unknown field"}},
+		},
+	}
+
+	for _, testCase := range testCases {
+		result, err := validateManifest([]byte(testOpenAPISpec), "types", "MysqlCluster", testCase.manifest)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", testCase.name, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(result.Errors, testCase.expected) {
+			t.Errorf("%s: expected errors %v, got %v", testCase.name, testCase.expected, result.Errors)
+		}
+	}
+}
+
+func TestValidateManifestSpecErrors(t *testing.T) {
+	testCases := []struct {
+		name             string
+		openAPISpec      string
+		definitionPrefix string
+	}{
+		{"unparsable", `{"definitions":`, "types"},
+		{"no definitions", `{"paths": {}}`, "types"},
+		{"Kind not defined", testOpenAPISpec, "other"},
+	}
+
+	manifest := map[string]interface{}{"kind": "MysqlCluster"}
+	for _, testCase := range testCases {
+		if _, err := validateManifest([]byte(testCase.openAPISpec), testCase.definitionPrefix, "MysqlCluster", manifest); err == nil {
+			t.Errorf("%s: expected an error", testCase.name)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/cloud-ark/kubediscovery/pkg/apiserver"
+	"github.com/cloud-ark/kubediscovery/pkg/discovery"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	genericapiserver "k8s.io/apiserver/pkg/server"
 	genericoptions "k8s.io/apiserver/pkg/server/options"
@@ -45,6 +46,10 @@ func NewCommandStartDiscoveryServer(defaults *DiscoveryServerOptions, stopCh <-c
 			if err := o.Validate(args); err != nil {
 				return err
 			}
+			// Start collecting provenance
+			if err := discovery.StartBuilder(c.Flags()); err != nil {
+				return err
+			}
 			if err := o.RunDiscoveryServer(stopCh); err != nil {
 				return err
 			}
@@ -54,6 +59,7 @@ func NewCommandStartDiscoveryServer(defaults *DiscoveryServerOptions, stopCh <-c
 
 	flags := cmd.Flags()
 	o.RecommendedOptions.AddFlags(flags)
+	discovery.AddFlags(flags)
 
 	return cmd
 }
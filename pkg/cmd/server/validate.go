@@ -0,0 +1,43 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/cloud-ark/kubediscovery/pkg/discovery"
+)
+
+// NewCommandValidateConfig provides a CLI handler for the 'validate-config' command,
+// which checks a Kind composition file without starting the server.
+func NewCommandValidateConfig(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "validate-config [file]",
+		Short: "Validate a Kind composition file",
+		Long: "Validate a Kind composition file. The file given by the KIND_COMPOSITION_FILE " +
+			"environment variable is validated if no file is given.",
+		Args:         cobra.MaximumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			filePath := os.Getenv("KIND_COMPOSITION_FILE")
+			if len(args) > 0 {
+				filePath = args[0]
+			}
+			if filePath == "" {
+				return fmt.Errorf("no file given and KIND_COMPOSITION_FILE is not set")
+			}
+			problems := discovery.ValidateKindCompositionFile(filePath)
+			for _, problem := range problems {
+				fmt.Fprintf(out, "%s: %s\n", filePath, problem)
+			}
+			if len(problems) > 0 {
+				return fmt.Errorf("%s is not valid, %d problem(s) found", filePath, len(problems))
+			}
+			fmt.Fprintf(out, "%s is valid\n", filePath)
+			return nil
+		},
+	}
+	return cmd
+}
@@ -0,0 +1,169 @@
+package discovery
+
+import (
+	"fmt"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+)
+
+// Used to decide whether the caller of an API request is allowed to see a resource
+type AccessChecker interface {
+	CanGet(resourceKind, namespace, name string) bool
+}
+
+// Used to check access of a user through SubjectAccessReviews.
+// Decisions are cached for the lifetime of the checker, which is a single API request.
+type subjectAccessChecker struct {
+	user      string
+	uid       string
+	groups    []string
+	extra     map[string][]string
+	decisions map[string]bool
+}
+
+// NewSubjectAccessChecker returns an AccessChecker that asks the main API server
+// whether the given user can 'get' a resource.
+func NewSubjectAccessChecker(user, uid string, groups []string, extra map[string][]string) AccessChecker {
+	return &subjectAccessChecker{
+		user:      user,
+		uid:       uid,
+		groups:    groups,
+		extra:     extra,
+		decisions: make(map[string]bool),
+	}
+}
+
+func (c *subjectAccessChecker) CanGet(resourceKind, namespace, name string) bool {
+	group, resource, found := getResourceGroup(resourceKind)
+	if !found {
+		// We cannot tell what the caller is allowed to see; fail closed
+		return false
+	}
+	// Most callers are allowed to get all the resources of a Kind in a namespace,
+	// so check that first and only fall back to the individual resource if needed.
+	if c.allowed(group, resource, namespace, "") {
+		return true
+	}
+	return c.allowed(group, resource, namespace, name)
+}
+
+func (c *subjectAccessChecker) allowed(group, resource, namespace, name string) bool {
+	key := strings.Join([]string{group, resource, namespace, name}, "/")
+	if decision, present := c.decisions[key]; present {
+		return decision
+	}
+
+	extra := make(map[string]authorizationv1.ExtraValue)
+	for k, v := range c.extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	sar := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   c.user,
+			UID:    c.uid,
+			Groups: c.groups,
+			Extra:  extra,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      "get",
+				Group:     group,
+				Resource:  resource,
+				Name:      name,
+			},
+		},
+	}
+
+	decision := false
+	client, err := getKubeClient()
+	if err == nil {
+		sar, err = client.AuthorizationV1().SubjectAccessReviews().Create(sar)
+	}
+	if err != nil {
+		fmt.Printf("Error: could not check access of %s to %s: %s\n", c.user, key, err.Error())
+	} else {
+		decision = sar.Status.Allowed
+	}
+	c.decisions[key] = decision
+	return decision
+}
+
+// getResourceGroup returns the API group and resource (plural) of the Kind.
+func getResourceGroup(resourceKind string) (string, string, bool) {
+	group, _, resourcePlural, found := getGroupVersionResource(lookupKind(resourceKind))
+	return group, resourcePlural, found && resourcePlural != ""
+}
+
+// lookupKind returns the registered Kind matching the given name irrespective of case.
+func lookupKind(resourceKind string) string {
+	if _, present := getRegistry().compositionMap[resourceKind]; present {
+		return resourceKind
+	}
+	for key := range getRegistry().compositionMap {
+		if strings.EqualFold(key, resourceKind) {
+			return key
+		}
+	}
+	return resourceKind
+}
+
+// filterCompositions removes the nodes that the caller is not allowed to get.
+// A node that the caller cannot get but which has descendants that the caller can
+// get is kept with everything but its Kind and namespace redacted (name, status, UID,
+// apiVersion, timestamps, labels, annotations and diagnostics) so that the tree remains
+// connected without revealing when the hidden object was created or is being deleted.
+func filterCompositions(compositions []Composition, accessChecker AccessChecker) []Composition {
+	filtered := []Composition{}
+	for _, composition := range compositions {
+		if visible, ok := filterComposition(composition, accessChecker); ok {
+			filtered = append(filtered, visible)
+		}
+	}
+	return filtered
+}
+
+func filterComposition(composition Composition, accessChecker AccessChecker) (Composition, bool) {
+	children := filterCompositions(composition.Children, accessChecker)
+	composition.Children = children
+	if accessChecker.CanGet(composition.Kind, composition.Namespace, composition.Name) {
+		if composition.Endpoints != nil {
+			composition.Endpoints = filterEndpointPods(*composition.Endpoints, composition.Namespace, accessChecker)
+		}
+		return composition, true
+	}
+	if len(children) == 0 {
+		return composition, false
+	}
+	composition.Name = ""
+	composition.Status = ""
+	composition.StatusReason = ""
+	composition.UID = ""
+	composition.APIVersion = ""
+	composition.ResourceVersion = ""
+	composition.CreationTimestamp = ""
+	composition.Age = ""
+	composition.DeletionTimestamp = ""
+	composition.Labels = nil
+	composition.Annotations = nil
+	composition.requests = nil
+	composition.limits = nil
+	composition.containers = nil
+	composition.Restarts = 0
+	composition.LastTerminationReason = ""
+	composition.WaitingReasons = nil
+	composition.Endpoints = nil
+	composition.Redacted = true
+	return composition, true
+}
+
+// filterEndpointPods removes the Pods that the caller is not allowed to get from the endpoints.
+func filterEndpointPods(endpoints ServiceEndpoints, namespace string, accessChecker AccessChecker) *ServiceEndpoints {
+	pods := []string{}
+	for _, pod := range endpoints.Pods {
+		if accessChecker.CanGet(POD, namespace, pod) {
+			pods = append(pods, pod)
+		}
+	}
+	endpoints.Pods = pods
+	return &endpoints
+}
@@ -0,0 +1,53 @@
+package discovery
+
+import (
+	"sync"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var (
+	kubeClient    kubernetes.Interface
+	kubeConfig    *rest.Config
+	kubeClientMux sync.Mutex
+)
+
+// getKubeConfig returns the configuration used for talking to the main API server.
+// It is built from the --kubeconfig and --master flags. When neither of them is set
+// the in-cluster configuration (service account token and CA) is used, so the same
+// code works both when kubediscovery runs as a Pod and when it runs on a laptop.
+func getKubeConfig() (*rest.Config, error) {
+	kubeClientMux.Lock()
+	defer kubeClientMux.Unlock()
+	if kubeConfig == nil {
+		cfg, err := clientcmd.BuildConfigFromFlags(masterURL, kubeconfig)
+		if err != nil {
+			return nil, err
+		}
+		// Client-side rate limiting shared by all the build workers
+		cfg.QPS = float32(kubeAPIQPS)
+		cfg.Burst = kubeAPIBurst
+		kubeConfig = cfg
+	}
+	return rest.CopyConfig(kubeConfig), nil
+}
+
+// getKubeClient returns the client shared by all queries to the main API server.
+func getKubeClient() (kubernetes.Interface, error) {
+	cfg, err := getKubeConfig()
+	if err != nil {
+		return nil, err
+	}
+	kubeClientMux.Lock()
+	defer kubeClientMux.Unlock()
+	if kubeClient == nil {
+		client, err := kubernetes.NewForConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		kubeClient = client
+	}
+	return kubeClient, nil
+}
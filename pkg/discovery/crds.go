@@ -0,0 +1,283 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	// Annotation on CRDs listing the Kinds of the underlying resources of their
+	// Custom Resources (KubePlus guideline #9), e.g. "Deployment, Service"
+	compositionAnnotation = "composition"
+	// Optional annotations on CRDs holding the status rule and the location of the
+	// OpenAPI Spec of their Custom Resources as JSON objects
+	statusRuleAnnotation  = "kubeplus.cloudark.io/status"
+	openAPISpecAnnotation = "kubeplus.cloudark.io/openapispec"
+	// Time after which the API server ends a watch; the resources are listed again then
+	watchTimeoutSeconds = "300"
+)
+
+// Used to decode the events of a watch
+type watchEvent struct {
+	Type   string
+	Object map[string]interface{}
+}
+
+// loadCRDCompositions rebuilds the CRD annotation layer of the Kind registry from the CRDs
+// that have a valid composition annotation; the others are reported in RegistryError. CRDs are listed through the apiextensions version
+// preferred by the API server. It returns the path and resourceVersion of the CRD list
+// from which changes can be watched, even if the layer could not be rebuilt.
+func loadCRDCompositions() (string, string, error) {
+	path, found, err := getClusterResourcePath("CustomResourceDefinition", "customresourcedefinitions")
+	if err == nil && !found {
+		err = fmt.Errorf("CustomResourceDefinitions are not served by the API server")
+	}
+	if err != nil {
+		err = fmt.Errorf("could not resolve CustomResourceDefinitions, keeping the last good entries of the %s layer: %s",
+			LAYER_CRD_ANNOTATION, err.Error())
+		setLayerError(LAYER_CRD_ANNOTATION, err)
+		return "", "", err
+	}
+	content, err := queryAPIServer(path, false)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			// The served version may have changed; resolve again in the next cycle
+			kindResolver.invalidate()
+		}
+		err = fmt.Errorf("could not list CustomResourceDefinitions, keeping the last good entries of the %s layer: %s",
+			LAYER_CRD_ANNOTATION, err.Error())
+		setLayerError(LAYER_CRD_ANNOTATION, err)
+		return "", "", err
+	}
+	var crdList map[string]interface{}
+	if err := json.Unmarshal(content, &crdList); err != nil {
+		err = fmt.Errorf("could not parse CustomResourceDefinitions, keeping the last good entries of the %s layer: %s",
+			LAYER_CRD_ANNOTATION, err.Error())
+		setLayerError(LAYER_CRD_ANNOTATION, err)
+		return "", "", err
+	}
+	resourceVersion := nestedString(crdList, "metadata", "resourceVersion")
+	// CRDs may have been added to a group/version that is already served, which the
+	// resolver does not notice by itself
+	kindResolver.invalidate()
+
+	entries := []layerEntry{}
+	problems := []string{}
+	for _, item := range nestedSlice(crdList, "items") {
+		crd, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		compositionObj, found, err := parseCRDComposition(crd)
+		if !found {
+			continue
+		}
+		crdName := nestedString(crd, "metadata", "name")
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("CRD %s: %s", crdName, err.Error()))
+			continue
+		}
+		if entryProblems := validateComposition(compositionObj); len(entryProblems) > 0 {
+			problems = append(problems, fmt.Sprintf("CRD %s: %s", crdName, strings.Join(entryProblems, "; ")))
+			continue
+		}
+		entries = append(entries, layerEntry{compositionObj, crdName})
+	}
+	// A CRD that is invalid on its own, or that would make the registry invalid, is skipped
+	// so that it does not hold back the CRDs of other teams
+	entries, hierarchyProblems := filterCRDAnnotationEntries(entries)
+	problems = append(problems, hierarchyProblems...)
+	if err := setLayer(LAYER_CRD_ANNOTATION, entries); err != nil {
+		return path, resourceVersion, err
+	}
+	if len(problems) > 0 {
+		err = fmt.Errorf("skipped CRDs with invalid annotations: %s", strings.Join(problems, "; "))
+		setLayerError(LAYER_CRD_ANNOTATION, err)
+		return path, resourceVersion, err
+	}
+	return path, resourceVersion, nil
+}
+
+// parseCRDComposition returns the registry entry of the Custom Resources of the CRD.
+// Kind, plural and group/version are taken from the CRD itself. It returns false if
+// the CRD has no composition annotation.
+func parseCRDComposition(crd map[string]interface{}) (composition, bool, error) {
+	compositionString, found := nestedField(crd, "metadata", "annotations", compositionAnnotation)
+	if !found {
+		return composition{}, false, nil
+	}
+	compositionObj := composition{
+		Kind:        nestedString(crd, "spec", "names", "kind"),
+		Plural:      nestedString(crd, "spec", "names", "plural"),
+		Composition: make([]string, 0),
+	}
+	group, version := nestedString(crd, "spec", "group"), crdVersion(crd)
+	if group == "" || version == "" {
+		return compositionObj, true, fmt.Errorf("group/version is not set")
+	}
+	compositionObj.Endpoint = "apis/" + group + "/" + version
+
+	compositionValue, _ := compositionString.(string)
+	for _, elem := range strings.Split(compositionValue, ",") {
+		elem = strings.TrimSpace(elem)
+		if elem != "" {
+			compositionObj.Composition = append(compositionObj.Composition, elem)
+		}
+	}
+
+	// Status extraction rule is optional
+	if statusRuleString := nestedString(crd, "metadata", "annotations", statusRuleAnnotation); statusRuleString != "" {
+		var statusRuleMap map[string]interface{}
+		if err := json.Unmarshal([]byte(statusRuleString), &statusRuleMap); err != nil {
+			return compositionObj, true, fmt.Errorf("could not parse %s annotation: %s", statusRuleAnnotation, err.Error())
+		}
+		compositionObj.Status = parseStatusRule(statusRuleMap)
+	}
+
+	// Location of the OpenAPI Spec is optional
+	if openAPISpecString := nestedString(crd, "metadata", "annotations", openAPISpecAnnotation); openAPISpecString != "" {
+		var openAPISpecMap map[string]interface{}
+		if err := json.Unmarshal([]byte(openAPISpecString), &openAPISpecMap); err != nil {
+			return compositionObj, true, fmt.Errorf("could not parse %s annotation: %s", openAPISpecAnnotation, err.Error())
+		}
+		compositionObj.OpenAPISpec = parseOpenAPISpecLocation(openAPISpecMap)
+	}
+	return compositionObj, true, nil
+}
+
+func parseOpenAPISpecLocation(openAPISpecMap map[string]interface{}) OpenAPISpecLocation {
+	location := OpenAPISpecLocation{}
+	location.Namespace, _ = openAPISpecMap["namespace"].(string)
+	location.ConfigMap, _ = openAPISpecMap["configmap"].(string)
+	location.Key, _ = openAPISpecMap["key"].(string)
+	location.DefinitionPrefix, _ = openAPISpecMap["definitionPrefix"].(string)
+	return location
+}
+
+// crdVersion returns the version through which Custom Resources of the CRD are queried:
+// its storage version if that is served, otherwise the first served version. CRDs listed
+// through apiextensions.k8s.io/v1 only have spec.versions; v1beta1 CRDs may only have
+// spec.version.
+func crdVersion(crd map[string]interface{}) string {
+	firstServed := ""
+	for _, version := range nestedSlice(crd, "spec", "versions") {
+		versionMap, ok := version.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if served, _ := versionMap["served"].(bool); !served {
+			continue
+		}
+		versionName := nestedString(versionMap, "name")
+		if storage, _ := versionMap["storage"].(bool); storage {
+			return versionName
+		}
+		if firstServed == "" {
+			firstServed = versionName
+		}
+	}
+	if firstServed != "" {
+		return firstServed
+	}
+	return nestedString(crd, "spec", "version")
+}
+
+// declareChildKinds adds the child Kinds that are not declared in the registry, so that
+// CRDs can list any Kind served by the API server in their composition annotation.
+// Group/version and plural of these Kinds are resolved through the discovery API.
+func (r *kindRegistry) declareChildKinds() {
+	childKinds := []string{}
+	for _, children := range r.compositionMap {
+		for _, childKind := range children {
+			if _, declared := r.compositionMap[childKind]; !declared && !contains(childKinds, childKind) {
+				childKinds = append(childKinds, childKind)
+			}
+		}
+	}
+	for _, childKind := range childKinds {
+		r.register(composition{Kind: childKind}, LAYER_DISCOVERY, "")
+	}
+}
+
+// watchCRDs reloads the Kind registry whenever a CRD is added, changed or deleted.
+func watchCRDs() {
+	watchList(loadCRDCompositions)
+}
+
+// watchList calls load, which lists resources and returns the path and resourceVersion
+// of the list, and calls it again whenever one of the resources changes. If no
+// resourceVersion is returned the resources are listed again after a while. Failed lists
+// and watches, including watches that end right away, are retried with the same backoff
+// as the build cycles.
+func watchList(load func() (string, string, error)) {
+	go func() {
+		failures := 0
+		for {
+			path, resourceVersion, err := load()
+			if err != nil {
+				fmt.Printf("Error: %s\n", err.Error())
+			}
+			if path == "" || resourceVersion == "" {
+				if err != nil {
+					failures++
+				} else {
+					failures = 0
+				}
+				time.Sleep(backoffDelay(failures))
+				continue
+			}
+			watchStarted := time.Now()
+			changed, err := waitForChange(path, resourceVersion)
+			switch {
+			case err != nil:
+				failures++
+				fmt.Printf("Error: watching %s: %s, retrying in %s\n", path, err.Error(), backoffDelay(failures))
+			case !changed && time.Since(watchStarted) < buildInterval:
+				// The API server closed the watch without it timing out
+				failures++
+				fmt.Printf("Error: watch of %s ended right away, retrying in %s\n", path, backoffDelay(failures))
+			default:
+				failures = 0
+				continue
+			}
+			time.Sleep(backoffDelay(failures))
+		}
+	}()
+}
+
+// waitForChange watches the resources under the path from the resourceVersion and
+// returns when one of them changed or the watch ended. It returns true in the former case.
+func waitForChange(path, resourceVersion string) (bool, error) {
+	client, err := getKubeClient()
+	if err != nil {
+		return false, err
+	}
+	stream, err := client.CoreV1().RESTClient().Get().AbsPath(path).
+		Param("watch", "true").
+		Param("resourceVersion", resourceVersion).
+		Param("timeoutSeconds", watchTimeoutSeconds).
+		Stream()
+	if err != nil {
+		return false, err
+	}
+	defer stream.Close()
+	decoder := json.NewDecoder(stream)
+	for {
+		var event watchEvent
+		if err := decoder.Decode(&event); err != nil {
+			// The watch timed out or the connection was closed
+			return false, nil
+		}
+		switch event.Type {
+		case "ADDED", "MODIFIED", "DELETED":
+			return true, nil
+		case "ERROR":
+			// e.g. the resourceVersion is too old
+			return false, fmt.Errorf("%s", nestedString(event.Object, "message"))
+		}
+	}
+}
@@ -1,33 +1,24 @@
 package discovery
 
 import (
-	"crypto/tls"
-	cert "crypto/x509"
 	"encoding/json"
-	"flag"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"net/http"
-	"net/url"
 	"os"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
-	"gopkg.in/yaml.v2"
+	"github.com/spf13/pflag"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 )
 
 var (
-	serviceHost    string
-	servicePort    string
 	Namespace      string
-	httpMethod     string
 	etcdServiceURL string
 
-	KindPluralMap  map[string]string
-	kindVersionMap map[string]string
-	compositionMap map[string][]string
-
 	REPLICA_SET  string
 	DEPLOYMENT   string
 	POD          string
@@ -39,23 +30,50 @@ var (
 	ETCD_CLUSTER string
 )
 
+// Accept header for requesting lists as PartialObjectMetadataList, with plain JSON as fallback
+const partialObjectMetadataListAccept = "application/json;as=PartialObjectMetadataList;v=v1;g=meta.k8s.io," +
+	"application/json;as=PartialObjectMetadataList;v=v1beta1;g=meta.k8s.io," +
+	"application/json"
+
 var (
 	masterURL   string
 	kubeconfig  string
 	etcdservers string
+
+	includeNamespaces        string
+	excludeNamespaces        string
+	includeNamespaceSelector string
+	excludeNamespaceSelector string
+
+	buildConcurrency int
+	kubeAPIQPS       float64
+	kubeAPIBurst     int
+	maxBuildBackoff  time.Duration
+)
+
+var (
+	// Flags of the composition tree builder; see AddFlags
+	builderFlags = pflag.NewFlagSet("discovery", pflag.ContinueOnError)
+	// Names of the builder flags that are shared with flags of the server command
+	sharedFlags []string
 )
 
 func init() {
 
-	flag.StringVar(&kubeconfig, "kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
-	flag.StringVar(&masterURL, "master", "", "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
-	flag.StringVar(&etcdservers, "etcd-servers", "", "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
+	builderFlags.StringVar(&kubeconfig, "kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
+	builderFlags.StringVar(&masterURL, "master", "", "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
+	builderFlags.StringVar(&etcdservers, "etcd-servers", "", "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
+
+	builderFlags.StringVar(&includeNamespaces, "include-namespaces", "", "Comma separated list of namespaces (globs allowed) to discover. All namespaces if empty.")
+	builderFlags.StringVar(&excludeNamespaces, "exclude-namespaces", "", "Comma separated list of namespaces (globs allowed) to skip.")
+	builderFlags.StringVar(&includeNamespaceSelector, "include-namespace-selector", "", "Label selector of namespaces to discover.")
+	builderFlags.StringVar(&excludeNamespaceSelector, "exclude-namespace-selector", "", "Label selector of namespaces to skip.")
+	builderFlags.IntVar(&buildConcurrency, "build-concurrency", 4, "Number of namespaces whose composition trees are built in parallel.")
+	builderFlags.Float64Var(&kubeAPIQPS, "kube-api-qps", 20, "Maximum queries per second to the Kubernetes API server.")
+	builderFlags.IntVar(&kubeAPIBurst, "kube-api-burst", 40, "Maximum burst of queries to the Kubernetes API server.")
+	builderFlags.DurationVar(&maxBuildBackoff, "max-build-backoff", time.Minute*5, "Maximum time to wait between build cycles while the Kubernetes API server is unavailable.")
 
-	flag.Parse()
-	serviceHost = os.Getenv("KUBERNETES_SERVICE_HOST")
-	servicePort = os.Getenv("KUBERNETES_SERVICE_PORT")
 	Namespace = "default"
-	httpMethod = http.MethodGet
 
 	etcdServiceURL = "http://localhost:2379"
 
@@ -69,84 +87,142 @@ func init() {
 	PV = "PersistentVolume"
 	ETCD_CLUSTER = "EtcdCluster"
 
-	KindPluralMap = make(map[string]string)
-	kindVersionMap = make(map[string]string)
-	compositionMap = make(map[string][]string, 0)
-
-	readKindCompositionFile()
-
-	// set basic data types
-	KindPluralMap[DEPLOYMENT] = "deployments"
-	kindVersionMap[DEPLOYMENT] = "apis/apps/v1"
-	compositionMap[DEPLOYMENT] = []string{"ReplicaSet"}
-
-	KindPluralMap[REPLICA_SET] = "replicasets"
-	kindVersionMap[REPLICA_SET] = "apis/extensions/v1beta1"
-	compositionMap[REPLICA_SET] = []string{"Pod"}
-
-	KindPluralMap[POD] = "pods"
-	kindVersionMap[POD] = "api/v1"
-	compositionMap[POD] = []string{}
-
-	KindPluralMap[SERVICE] = "services"
-	kindVersionMap[SERVICE] = "api/v1"
-	compositionMap[SERVICE] = []string{}
-
-	KindPluralMap[SECRET] = "secrets"
-	kindVersionMap[SECRET] = "api/v1"
-	compositionMap[SECRET] = []string{}
+	// Start with the built-in layer only; the other layers are loaded by BuildCompositionTree
+	// so that commands such as validate-config neither read KIND_COMPOSITION_FILE nor query
+	// the API server
+	setLayer(LAYER_FILE, nil)
+}
 
-	KindPluralMap[PVCLAIM] = "persistentvolumeclaims"
-	kindVersionMap[PVCLAIM] = "api/v1"
-	compositionMap[PVCLAIM] = []string{}
+// AddFlags adds the flags of the composition tree builder to the flags of the server
+// command, so that they are parsed together with the flags of the generic API server.
+// Flags that the server command already has (e.g. --kubeconfig and --etcd-servers of
+// the generic API server options) are not added again but shared with the builder.
+func AddFlags(fs *pflag.FlagSet) {
+	builderFlags.VisitAll(func(f *pflag.Flag) {
+		if fs.Lookup(f.Name) != nil {
+			sharedFlags = append(sharedFlags, f.Name)
+			return
+		}
+		fs.AddFlag(f)
+	})
+}
 
-	KindPluralMap[PV] = "persistentvolumes"
-	kindVersionMap[PV] = "api/v1/persistentvolumes"
-	compositionMap[PV] = []string{}
+// StartBuilder starts building the composition trees. It must be called once the flags
+// of the server command have been parsed.
+func StartBuilder(fs *pflag.FlagSet) error {
+	for _, name := range sharedFlags {
+		if f := fs.Lookup(name); f != nil && f.Changed {
+			if err := builderFlags.Set(name, f.Value.String()); err != nil {
+				return err
+			}
+		}
+	}
+	go BuildCompositionTree()
+	return nil
 }
 
 func BuildCompositionTree() {
-	for {
-		err := readKindCompositionFile()
-		if err != nil {
+	// The layers of the Kind registry are reloaded when the Kind composition file, the
+	// CRDs or the KindComposition objects change
+	filePath, watchingFile := os.LookupEnv("KIND_COMPOSITION_FILE")
+	if watchingFile {
+		if err := loadKindCompositionFile(filePath); err != nil {
 			fmt.Printf("Error: %s\n", err.Error())
 		}
+		if err := watchKindCompositionFile(filePath); err != nil {
+			fmt.Printf("Error: could not watch %s, checking it every cycle instead: %s\n", filePath, err.Error())
+			watchingFile = false
+		}
+	}
+	watchCRDs()
+	watchKindCompositions()
+	for {
+		if filePath != "" && !watchingFile {
+			if err := loadKindCompositionFile(filePath); err != nil {
+				fmt.Printf("Error: %s\n", err.Error())
+			}
+		}
+		if err := kindResolver.refresh(); err != nil {
+			fmt.Printf("Error: could not resolve Kinds: %s\n", err.Error())
+		}
 		resourceKindList := getResourceKinds()
-		namespaces := getAllNamespaces()
-
-		resourceInCluster := []MetaDataAndOwnerReferences{}
-		for _, resourceKind := range resourceKindList {
-			for _, namespace := range namespaces {
-				topLevelMetaDataOwnerRefList := getResourceNames(resourceKind, namespace)
-				// fmt.Printf("TopLevelMetaDataOwnerRefList: %s: %v\n", namespace, topLevelMetaDataOwnerRefList)
-				for _, topLevelObject := range topLevelMetaDataOwnerRefList {
-					resourceName := topLevelObject.MetaDataName
-					namespace := topLevelObject.Namespace
-					level := 1
-					compositionTree := []CompositionTreeNode{}
-					buildCompositions(resourceKind, resourceName, namespace, level, &compositionTree)
-					TotalClusterCompositions.storeCompositions(topLevelObject, resourceKind, resourceName, namespace, &compositionTree)
-				}
-				for _, resource := range topLevelMetaDataOwnerRefList {
-					present := false
-					for _, res := range resourceInCluster {
-						if res.MetaDataName == resource.MetaDataName {
-							present = true
-						}
-					}
-					if !present {
-						resourceInCluster = append(resourceInCluster, resource)
-					}
+		namespaces, err := getNamespaces()
+		if err != nil {
+			fmt.Printf("Error: could not list namespaces: %s\n", err.Error())
+			// Keep serving the compositions of the last good cycle
+			time.Sleep(buildSyncTracker.cycleCompleted(0, 1, []string{"could not list namespaces: " + err.Error()}))
+			continue
+		}
+
+		// Namespaces are independent of each other, so they are built by a bounded pool of workers
+		workers := buildConcurrency
+		if workers < 1 {
+			workers = 1
+		}
+		namespaceChannel := make(chan string)
+		var wg sync.WaitGroup
+		var resultMux sync.Mutex
+		succeeded, failed := 0, 0
+		cycleErrors := []string{}
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for namespace := range namespaceChannel {
+					namespaceSucceeded, queryErrors := buildNamespaceCompositions(resourceKindList, namespace)
+					resultMux.Lock()
+					succeeded = succeeded + namespaceSucceeded
+					failed = failed + len(queryErrors)
+					cycleErrors = append(cycleErrors, queryErrors...)
+					resultMux.Unlock()
 				}
-			}
+			}()
+		}
+		for _, namespace := range namespaces {
+			namespaceChannel <- namespace
 		}
+		close(namespaceChannel)
+		wg.Wait()
 
-		TotalClusterCompositions.purgeCompositionOfDeletedItems(resourceInCluster)
+		TotalClusterCompositions.purgeCompositionOfDeletedNamespaces(namespaces)
 
-		time.Sleep(time.Second * 10)
+		sort.Strings(cycleErrors)
+		time.Sleep(buildSyncTracker.cycleCompleted(succeeded, failed, cycleErrors))
 	}
 }
 
+// buildNamespaceCompositions builds the composition trees of all the instances of the given
+// Kinds in the namespace and then replaces the namespace's entries in the store in one step.
+// It returns the number of successful queries and the errors of the failed ones.
+func buildNamespaceCompositions(resourceKindList []string, namespace string) (int, []string) {
+	namespaceCompositions := []Compositions{}
+	queryErrors := make(map[string]string)
+	snapshot := newClusterSnapshot()
+	for _, resourceKind := range resourceKindList {
+		topLevelMetaDataOwnerRefList, err := snapshot.getResources(resourceKind, namespace)
+		if err != nil {
+			queryErrors[resourceKind] = queryErrorMessage(resourceKind, namespace, err)
+			continue
+		}
+		// fmt.Printf("TopLevelMetaDataOwnerRefList: %s: %v\n", namespace, topLevelMetaDataOwnerRefList)
+		for _, topLevelObject := range topLevelMetaDataOwnerRefList {
+			resourceName := topLevelObject.MetaDataName
+			level := 1
+			compositionTree := []CompositionTreeNode{}
+			buildCompositions(snapshot, resourceKind, resourceName, namespace, level, &compositionTree)
+			compositions := newCompositions(topLevelObject, resourceKind, resourceName, namespace, &compositionTree)
+			namespaceCompositions = append(namespaceCompositions, compositions)
+		}
+	}
+	TotalClusterCompositions.storeNamespaceCompositions(namespace, namespaceCompositions, queryErrors)
+
+	snapshotErrors := []string{}
+	for key, err := range snapshot.errors {
+		parts := strings.SplitN(key, "/", 2)
+		snapshotErrors = append(snapshotErrors, queryErrorMessage(parts[0], namespace, err))
+	}
+	return len(snapshot.resources), snapshotErrors
+}
 func (cp *ClusterCompositions) checkIfProvenanceNeeded(resourceKind, resourceName string) bool {
 	cp.mux.Lock()
 	defer cp.mux.Unlock()
@@ -160,70 +236,42 @@ func (cp *ClusterCompositions) checkIfProvenanceNeeded(resourceKind, resourceNam
 	return true
 }
 
-func readKindCompositionFile() error {
-	// read from the opt file
-	filePath, ok := os.LookupEnv("KIND_COMPOSITION_FILE")
-	if ok {
-		yamlFile, err := ioutil.ReadFile(filePath)
-		if err != nil {
-			return err
-		}
-
-		compositionsList := make([]composition, 0)
-		err = yaml.Unmarshal(yamlFile, &compositionsList)
-		if err != nil {
-			return err
-		}
-		for _, compositionObj := range compositionsList {
-			kind := compositionObj.Kind
-			endpoint := compositionObj.Endpoint
-			composition := compositionObj.Composition
-			plural := compositionObj.Plural
-			//fmt.Printf("Kind:%s, Plural: %s Endpoint:%s, Composition:%s\n", kind, plural, endpoint, composition)
-
-			KindPluralMap[kind] = plural
-			kindVersionMap[kind] = endpoint
-			compositionMap[kind] = composition
-		}
-	} else {
-		// Populate the Kind maps by querying CRDs from ETCD and querying KAPI for details of each CRD
-		crdListString, err := queryETCD("/operators")
-		if err != nil {
-			return err
-		}
-		if crdListString != "" {
-			crdNameList := getCRDNames(crdListString)
-			for _, crdName := range crdNameList {
-				crdDetailsString, err := queryETCD("/" + crdName)
-				if err != nil {
-					return err
-				}
-				kind, plural, endpoint, composition := getCRDDetails(crdDetailsString)
-
-				KindPluralMap[kind] = plural
-				kindVersionMap[kind] = endpoint
-				compositionMap[kind] = composition
-			}
+func getResourceNames(resourceKind, namespace string) ([]MetaDataAndOwnerReferences, error) {
+	path, err := getResourcePath(resourceKind, namespace)
+	if err != nil {
+		return nil, err
+	}
+	content, err := queryAPIServer(path, getRegistry().metadataOnlyMap[resourceKind])
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			// The served version may have changed (e.g. CRD upgrade); resolve again in the next cycle
+			kindResolver.invalidate()
 		}
+		return nil, err
 	}
-	//printMaps()
-	return nil
+	metaDataList, err := parseMetaData(resourceKind, content)
+	if err != nil {
+		return nil, err
+	}
+	// Items of lists of built-in Kinds and of PartialObjectMetadataLists do not carry
+	// the apiVersion of the resource, so it is set from the resolved group/version.
+	apiVersion := getAPIVersion(resourceKind)
+	for i := range metaDataList {
+		metaDataList[i].APIVersion = apiVersion
+	}
+	return metaDataList, nil
 }
 
-func getResourceKinds() []string {
-	resourceKindSlice := make([]string, 0)
-	for key, _ := range compositionMap {
-		resourceKindSlice = append(resourceKindSlice, key)
-	}
-	return resourceKindSlice
+func queryErrorKey(resourceKind, namespace string) string {
+	return resourceKind + "/" + namespace
 }
 
-func getResourceNames(resourceKind, namespace string) []MetaDataAndOwnerReferences {
-	resourceApiVersion := kindVersionMap[resourceKind]
-	resourceKindPlural := KindPluralMap[resourceKind]
-	content := queryAPIServer(resourceApiVersion, resourceKindPlural, namespace)
-	metaDataAndOwnerReferenceList := parseMetaData(content)
-	return metaDataAndOwnerReferenceList
+func queryErrorMessage(resourceKind, namespace string, err error) string {
+	resourceKindPlural := getResourcePlural(resourceKind)
+	if resourceKindPlural == "" {
+		resourceKindPlural = resourceKind
+	}
+	return fmt.Sprintf("could not list %s in ns %s: %s", resourceKindPlural, namespace, err.Error())
 }
 
 func processed(processedList *[]CompositionTreeNode, nodeToCheck CompositionTreeNode) bool {
@@ -238,7 +286,7 @@ func processed(processedList *[]CompositionTreeNode, nodeToCheck CompositionTree
 	return result
 }
 
-func getComposition(kind, name, namespace, status string, level int, compositionTree *[]CompositionTreeNode,
+func getComposition(kind string, metaData MetaDataAndOwnerReferences, level int, compositionTree *[]CompositionTreeNode,
 	processedList *[]CompositionTreeNode) Composition {
 	//var compositionsString string
 	//fmt.Printf("-- Kind: %s Name: %s\n", kind, name)
@@ -246,9 +294,11 @@ func getComposition(kind, name, namespace, status string, level int, composition
 	parentComposition := Composition{}
 	parentComposition.Level = level
 	parentComposition.Kind = kind
-	parentComposition.Name = name
-	parentComposition.Namespace = namespace
-	parentComposition.Status = status
+	parentComposition.Name = metaData.MetaDataName
+	parentComposition.Namespace = metaData.Namespace
+	parentComposition.Status = metaData.Status
+	parentComposition.StatusReason = metaData.StatusReason
+	setMetaData(&parentComposition, metaData)
 	parentComposition.Children = []Composition{}
 
 	//fmt.Printf("CompositionTree:%v\n", compositionTree)
@@ -263,11 +313,6 @@ func getComposition(kind, name, namespace, status string, level int, composition
 
 		for _, metaDataNode := range metaDataAndOwnerReferences {
 			//compositionsString = compositionsString + " " + string(level) + " " + childKind + " " + childName + "\n"
-			childName := metaDataNode.MetaDataName
-			childNamespace := metaDataNode.Namespace
-
-			// childNamespace := metaDataNode.MetaDataNamespace
-			childStatus := metaDataNode.Status
 			trimmedTree := []CompositionTreeNode{}
 			for _, compositionTreeNode1 := range *compositionTree {
 				if compositionTreeNode1.Level != level && compositionTreeNode1.ChildKind != childKind {
@@ -275,7 +320,7 @@ func getComposition(kind, name, namespace, status string, level int, composition
 				}
 			}
 			*processedList = append(*processedList, compositionTreeNode)
-			child := getComposition(childKind, childName, childNamespace, childStatus, level, &trimmedTree, processedList)
+			child := getComposition(childKind, metaDataNode, level, &trimmedTree, processedList)
 			parentComposition.Children = append(parentComposition.Children, child)
 			compositionTree = &[]CompositionTreeNode{}
 		}
@@ -283,14 +328,52 @@ func getComposition(kind, name, namespace, status string, level int, composition
 	return parentComposition
 }
 
-func (cp *ClusterCompositions) GetCompositions(resourceKind, resourceName, namespace string) string {
+// GetCompositions returns the composition trees of the requested instance(s) as JSON.
+// Problems encountered while building a tree are listed in its Warnings.
+// An error is returned if the requested Kind could not be queried in the namespace
+// and hence no trees are available.
+// If accessChecker is not nil, nodes that the caller is not allowed to get are
+// removed or redacted. The labels and annotations of the nodes are selected by options.
+func (cp *ClusterCompositions) GetCompositions(resourceKind, resourceName, namespace string,
+	accessChecker AccessChecker, options CompositionOptions) (string, error) {
+	compositions, err := cp.getCompositionList(resourceKind, resourceName, namespace)
+	if err != nil {
+		return "", err
+	}
+
+	// Access checks query the main API server, so they are done without holding the lock
+	if accessChecker != nil {
+		compositions = filterCompositions(compositions, accessChecker)
+	}
+	// Health is rolled up after filtering so that the reasons only name visible resources
+	for i := range compositions {
+		rollUpHealth(&compositions[i])
+		rollUpResources(&compositions[i])
+		rollUpRestarts(&compositions[i])
+	}
+	filterMetaData(compositions, options)
+	if options.Events && len(compositions) > 0 {
+		if err := attachEvents(compositions, namespace, accessChecker); err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			for i := range compositions {
+				compositions[i].Warnings = append(compositions[i].Warnings, err.Error())
+			}
+		}
+	}
+
+	compositionBytes, err := json.Marshal(compositions)
+	if err != nil {
+		return "", err
+	}
+	return string(compositionBytes), nil
+}
+
+func (cp *ClusterCompositions) getCompositionList(resourceKind, resourceName, namespace string) ([]Composition, error) {
 	cp.mux.Lock()
 	defer cp.mux.Unlock()
-	var compositionBytes []byte
-	var compositionString string
 	compositions := []Composition{}
 
-	resourceKindPlural := KindPluralMap[resourceKind]
+	resourceKindPlural := getResourcePlural(resourceKind)
 	//fmt.Println("Compositions of different Kinds in this Cluster")
 	//fmt.Printf("Kind:%s, Name:%s\n", resourceKindPlural, resourceName)
 	fmt.Println(len(cp.clusterCompositions))
@@ -298,7 +381,9 @@ func (cp *ClusterCompositions) GetCompositions(resourceKind, resourceName, names
 		kind := strings.ToLower(compositionItem.Kind)
 		name := strings.ToLower(compositionItem.Name)
 		nmspace := strings.ToLower(compositionItem.Namespace)
-		status := compositionItem.Status
+		metaData := compositionItem.MetaData
+		metaData.MetaDataName = name
+		metaData.Namespace = nmspace
 		compositionTree := compositionItem.CompositionTree
 		resourceKindPlural := strings.ToLower(resourceKindPlural)
 		//TODO(devdattakulkarni): Make route registration and compositions keyed info
@@ -306,8 +391,8 @@ func (cp *ClusterCompositions) GetCompositions(resourceKind, resourceName, names
 		//singular kind names. For now, trimming the 's' at the end
 		//resourceKind = strings.TrimSuffix(resourceKind, "s")
 		var resourceKind string
-		for key, value := range KindPluralMap {
-			if strings.ToLower(value) == strings.ToLower(resourceKindPlural) {
+		for key := range getRegistry().compositionMap {
+			if strings.ToLower(getResourcePlural(key)) == strings.ToLower(resourceKindPlural) {
 				resourceKind = strings.ToLower(key)
 				break
 			}
@@ -321,94 +406,150 @@ func (cp *ClusterCompositions) GetCompositions(resourceKind, resourceName, names
 		case resourceName == "*" && resourceKind == kind && namespace == nmspace:
 			processedList := []CompositionTreeNode{}
 			level := 1
-			composition := getComposition(kind, name, namespace, status, level, compositionTree, &processedList)
+			composition := getComposition(kind, metaData, level, compositionTree, &processedList)
+			composition.Warnings = compositionItem.Warnings
+			setSyncState(&composition, compositionItem)
 			compositions = append(compositions, composition)
 			break
 		case resourceName == name && resourceKind == kind && namespace == nmspace:
 			processedList := []CompositionTreeNode{}
 			level := 1
-			composition := getComposition(kind, name, namespace, status, level, compositionTree, &processedList)
+			composition := getComposition(kind, metaData, level, compositionTree, &processedList)
+			composition.Warnings = compositionItem.Warnings
+			setSyncState(&composition, compositionItem)
 			compositions = append(compositions, composition)
 			break
 		}
 	}
 
-	compositionBytes, err := json.Marshal(compositions)
-	if err != nil {
-		fmt.Println(err)
+	queryError, queryFailed := cp.queryErrors[namespace][resourceKind]
+	if queryFailed {
+		if len(compositions) == 0 {
+			return nil, errors.New(queryError)
+		}
+		for i := range compositions {
+			compositions[i].Warnings = append(compositions[i].Warnings, queryError)
+		}
 	}
-	compositionString = string(compositionBytes)
-	return compositionString
+	return compositions, nil
 }
 
-func (cp *ClusterCompositions) purgeCompositionOfDeletedItems(topLevelMetaDataOwnerRefList []MetaDataAndOwnerReferences) {
+func setSyncState(composition *Composition, compositionItem Compositions) {
+	if !compositionItem.Stale {
+		return
+	}
+	composition.Stale = true
+	if !compositionItem.LastSynced.IsZero() {
+		composition.LastSyncAge = syncAge(compositionItem.LastSynced)
+	}
+}
+
+func (cp *ClusterCompositions) purgeCompositionOfDeletedNamespaces(namespaces []string) {
+	cp.mux.Lock()
+	defer cp.mux.Unlock()
 	presentList := []Compositions{}
 	//fmt.Println("ClusterCompositions:%v\n", cp.clusterCompositions)
-	//fmt.Println("ToplevelMetaDataOwnerList:%v\n", topLevelMetaDataOwnerRefList)
 	for _, compositionItem := range cp.clusterCompositions {
-		for _, topLevelObject := range topLevelMetaDataOwnerRefList {
-			resourceName := topLevelObject.MetaDataName
-			//fmt.Printf("ResourceName:%s, prov.Name:%s\n", resourceName, prov.Name)
-			if resourceName == compositionItem.Name {
-				presentList = append(presentList, compositionItem)
-			}
+		if contains(namespaces, compositionItem.Namespace) {
+			presentList = append(presentList, compositionItem)
 		}
 	}
 	//fmt.Printf("Updated Cluster Prov List:%v\n", presentList)
 	cp.clusterCompositions = presentList
+	for namespace := range cp.queryErrors {
+		if !contains(namespaces, namespace) {
+			delete(cp.queryErrors, namespace)
+		}
+	}
 }
-
-// This stores Compositions information in memory. The compositions information will be lost
-// when this Pod is deleted.
-func (cp *ClusterCompositions) storeCompositions(topLevelObject MetaDataAndOwnerReferences,
+func newCompositions(topLevelObject MetaDataAndOwnerReferences,
 	resourceKind, resourceName, namespace string,
-	compositionTree *[]CompositionTreeNode) {
-	cp.mux.Lock()
-	defer cp.mux.Unlock()
+	compositionTree *[]CompositionTreeNode) Compositions {
+	warnings := []string{}
+	for _, compositionTreeNode := range *compositionTree {
+		if compositionTreeNode.Error != "" && !contains(warnings, compositionTreeNode.Error) {
+			warnings = append(warnings, compositionTreeNode.Error)
+		}
+	}
 	compositions := Compositions{
 		Kind:            resourceKind,
 		Name:            resourceName,
 		Namespace:       namespace,
 		Status:          topLevelObject.Status,
+		StatusReason:    topLevelObject.StatusReason,
+		MetaData:        topLevelObject,
 		CompositionTree: compositionTree,
+		Warnings:        warnings,
+	}
+	if len(warnings) == 0 {
+		compositions.LastSynced = time.Now()
+	}
+	return compositions
+}
+
+// This stores Compositions information in memory. The compositions information will be lost
+// when this Pod is deleted.
+// All the entries of the namespace, along with the errors encountered while querying it,
+// are replaced at once so that readers never see a partially updated namespace.
+// Entries whose source lists failed are not wiped out: the entries of a Kind that could
+// not be listed are kept as they are, and a tree in which some children could not be
+// listed is replaced by the last tree that was built without errors.
+func (cp *ClusterCompositions) storeNamespaceCompositions(namespace string, namespaceCompositions []Compositions,
+	queryErrors map[string]string) {
+	cp.mux.Lock()
+	defer cp.mux.Unlock()
+	updatedList := []Compositions{}
+	previousCompositions := make(map[string]Compositions)
+	for _, compositionItem := range cp.clusterCompositions {
+		if compositionItem.Namespace != namespace {
+			updatedList = append(updatedList, compositionItem)
+			continue
+		}
+		if _, failed := queryErrors[compositionItem.Kind]; failed {
+			compositionItem.Stale = true
+			updatedList = append(updatedList, compositionItem)
+			continue
+		}
+		previousCompositions[compositionItem.Kind+"/"+compositionItem.Name] = compositionItem
+	}
+	for _, compositionItem := range namespaceCompositions {
+		previous, present := previousCompositions[compositionItem.Kind+"/"+compositionItem.Name]
+		if len(compositionItem.Warnings) > 0 && present && !previous.LastSynced.IsZero() {
+			compositionItem.CompositionTree = previous.CompositionTree
+			compositionItem.LastSynced = previous.LastSynced
+			compositionItem.Stale = true
+		}
+		updatedList = append(updatedList, compositionItem)
 	}
-	present := false
-	// If prov already exists then replace status and composition Tree
-	//fmt.Printf("00 CP:%v\n", cp.clusterCompositions)
-	for i, comp := range cp.clusterCompositions {
-		if comp.Kind == compositions.Kind && comp.Name == compositions.Name && comp.Namespace == compositions.Namespace {
-			present = true
-			p := &comp
-			//fmt.Printf("CompositionTree:%v\n", compositionTree)
-			p.CompositionTree = compositionTree
-			p.Status = topLevelObject.Status
-			cp.clusterCompositions[i] = *p
-			//fmt.Printf("11 CP:%v\n", cp.clusterCompositions)
-		}
-	}
-	if !present {
-		cp.clusterCompositions = append(cp.clusterCompositions, compositions)
-		//fmt.Printf("22 CP:%v\n", cp.clusterCompositions)
-	}
-	//fmt.Println("Exiting storeCompositions")
+	cp.clusterCompositions = updatedList
+	if cp.queryErrors == nil {
+		cp.queryErrors = make(map[string]map[string]string)
+	}
+	cp.queryErrors[namespace] = queryErrors
 	//fmt.Printf("ClusterCompositions:%v\n", cp.clusterCompositions)
 }
 
-func buildCompositions(parentResourceKind string, parentResourceName string, parentNamespace string, level int,
-	compositionTree *[]CompositionTreeNode) {
-	childResourceKindList, present := compositionMap[parentResourceKind]
+// buildCompositions assembles the composition tree of the given resource from the
+// resources listed in the snapshot of the current build cycle.
+func buildCompositions(snapshot *clusterSnapshot, parentResourceKind string, parentResourceName string,
+	parentNamespace string, level int, compositionTree *[]CompositionTreeNode) {
+	childResourceKindList, present := getRegistry().compositionMap[parentResourceKind]
 	if present {
 		level = level + 1
 
 		for _, childResourceKind := range childResourceKindList {
-			childKindPlural := KindPluralMap[childResourceKind]
-			childResourceApiVersion := kindVersionMap[childResourceKind]
-			var content []byte
-			var metaDataAndOwnerReferenceList []MetaDataAndOwnerReferences
-			content = queryAPIServer(childResourceApiVersion, childKindPlural, parentNamespace)
-			metaDataAndOwnerReferenceList = parseMetaData(content)
-
-			childrenList := filterChildren(&metaDataAndOwnerReferenceList, parentResourceName)
+			childrenList, err := snapshot.getChildren(childResourceKind, parentNamespace, parentResourceName)
+			if err != nil {
+				// Record the failure in the tree instead of treating it as 'no children'
+				*compositionTree = append(*compositionTree, CompositionTreeNode{
+					Level:     level,
+					ChildKind: childResourceKind,
+					Children:  []MetaDataAndOwnerReferences{},
+					Error:     queryErrorMessage(childResourceKind, parentNamespace, err),
+				})
+				continue
+			}
+
 			compTreeNode := CompositionTreeNode{
 				Level:     level,
 				ChildKind: childResourceKind,
@@ -420,251 +561,127 @@ func buildCompositions(parentResourceKind string, parentResourceName string, par
 			for _, metaDataRef := range childrenList {
 				resourceName := metaDataRef.MetaDataName
 				resourceKind := childResourceKind
-				buildCompositions(resourceKind, resourceName, parentNamespace, level, compositionTree)
+				buildCompositions(snapshot, resourceKind, resourceName, parentNamespace, level, compositionTree)
 			}
 		}
 	} else {
 		return
 	}
 }
-func getAllNamespaces() []string {
-	var url1 string
-	url1 = fmt.Sprintf("https://%s:%s/%s/namespaces", serviceHost, servicePort, "api/v1")
-
-	//fmt.Printf("Url:%s\n",url1)
-	caToken := getToken()
-	caCertPool := getCACert()
-	u, err := url.Parse(url1)
-	if err != nil {
-		panic(err)
-	}
-	req, err := http.NewRequest(httpMethod, u.String(), nil)
-	if err != nil {
-		fmt.Println(err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", string(caToken)))
-	client := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				RootCAs: caCertPool,
-			},
-		},
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("sending request failed: %s", err.Error())
-		fmt.Println(err)
-	}
-	defer resp.Body.Close()
-	resp_body, _ := ioutil.ReadAll(resp.Body)
-
-	return parseNamespacesResponse(resp_body)
-}
 
-func queryAPIServer(resourceApiVersion, resourcePlural, namespace string) []byte {
-	var url1 string
-	if !strings.Contains(resourceApiVersion, resourcePlural) {
-		url1 = fmt.Sprintf("https://%s:%s/%s/namespaces/%s/%s", serviceHost, servicePort, resourceApiVersion, namespace, resourcePlural)
-	} else {
-		url1 = fmt.Sprintf("https://%s:%s/%s", serviceHost, servicePort, resourceApiVersion)
-	}
-	//fmt.Printf("Url:%s\n",url1)
-	caToken := getToken()
-	caCertPool := getCACert()
-	u, err := url.Parse(url1)
+// queryAPIServer returns the raw list of resources of the given Kind in the namespace.
+// Failed requests as well as non-2xx responses (e.g. 403 or 404) are returned as errors.
+// If metadataOnly is set the list is requested as a PartialObjectMetadataList so that
+// the spec, status and data of the resources (e.g. Secret data) are not transferred.
+// API servers that do not support it fall back to returning the complete objects.
+func queryAPIServer(path string, metadataOnly bool) ([]byte, error) {
+	//fmt.Printf("Path:%s\n",path)
+	client, err := getKubeClient()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	req, err := http.NewRequest(httpMethod, u.String(), nil)
-	if err != nil {
-		fmt.Println(err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", string(caToken)))
-	client := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				RootCAs: caCertPool,
-			},
-		},
-	}
-	resp, err := client.Do(req)
+	req := client.CoreV1().RESTClient().Get().AbsPath(path)
+	if metadataOnly {
+		req = req.SetHeader("Accept", partialObjectMetadataListAccept)
+	}
+	resp_body, err := req.DoRaw()
 	if err != nil {
 		log.Printf("sending request failed: %s", err.Error())
-		fmt.Println(err)
+		return nil, err
 	}
-	defer resp.Body.Close()
-	resp_body, _ := ioutil.ReadAll(resp.Body)
 
-	//fmt.Println(resp.Status)
 	//fmt.Println(string(resp_body))
 	//fmt.Println("Exiting queryAPIServer")
-	return resp_body
+	return resp_body, nil
 }
 
 //Ref:https://www.sohamkamani.com/blog/2017/10/18/parsing-json-in-golang/#unstructured-data
-func parseMetaData(content []byte) []MetaDataAndOwnerReferences {
+// The content can either be a list of complete objects or a PartialObjectMetadataList,
+// in which case no status is available.
+// The Status of each resource is set by the health evaluator of resourceKind.
+func parseMetaData(resourceKind string, content []byte) ([]MetaDataAndOwnerReferences, error) {
 	//fmt.Println("Entering parseMetaData")
 	var result map[string]interface{}
-	json.Unmarshal([]byte(content), &result)
+	if err := json.Unmarshal([]byte(content), &result); err != nil {
+		return nil, fmt.Errorf("could not parse list: %s", err.Error())
+	}
 	// We need to parse following from the result
 	// metadata.name
 	// metadata.ownerReferences.name
 	// metadata.ownerReferences.kind
 	// metadata.ownerReferences.apiVersion
 	metaDataSlice := []MetaDataAndOwnerReferences{}
+	if _, ok := result["items"]; !ok {
+		return nil, fmt.Errorf("response is not a list: %s", string(content))
+	}
 	items, ok := result["items"].([]interface{})
 
 	if ok {
 		for _, item := range items {
-			//fmt.Println("=======================")
-			itemConverted := item.(map[string]interface{})
-			var metadataProcessed, statusProcessed bool
-			metaDataRef := MetaDataAndOwnerReferences{}
-			statusKeyExists := false
-			for key, _ := range itemConverted {
-				if key == "status" {
-					statusKeyExists = true
-				}
+			itemConverted, ok := item.(map[string]interface{})
+			if !ok {
+				continue
 			}
-			for key, value := range itemConverted {
-				if key == "metadata" {
-					//fmt.Println("----")
-					//fmt.Println(key, value.(interface{}))
-					metadataMap := value.(map[string]interface{})
-					for mkey, mvalue := range metadataMap {
-						//fmt.Printf("%v ==> %v\n", mkey, mvalue.(interface{}))
-						if mkey == "ownerReferences" {
-							ownerReferencesList := mvalue.([]interface{})
-							for _, ownerReference := range ownerReferencesList {
-								ownerReferenceMap := ownerReference.(map[string]interface{})
-								for okey, ovalue := range ownerReferenceMap {
-									//fmt.Printf("%v --> %v\n", okey, ovalue)
-									if okey == "name" {
-										metaDataRef.OwnerReferenceName = ovalue.(string)
-									}
-									if okey == "kind" {
-										metaDataRef.OwnerReferenceKind = ovalue.(string)
-									}
-									if okey == "apiVersion" {
-										metaDataRef.OwnerReferenceAPIVersion = ovalue.(string)
-									}
-								}
-							}
-						}
-						if mkey == "namespace" {
-							metaDataRef.Namespace = mvalue.(string)
-						}
-						if mkey == "name" {
-							metaDataRef.MetaDataName = mvalue.(string)
-						}
-					}
-					metadataProcessed = true
-				}
-				if key == "status" {
-					statusMap := value.(map[string]interface{})
-					var replicas, readyReplicas, availableReplicas float64
-					for skey, svalue := range statusMap {
-						if skey == "phase" {
-							metaDataRef.Status = svalue.(string)
-							//fmt.Printf("Status:%s\n", metaDataRef.Status)
-						}
-						if skey == "replicas" {
-							replicas = svalue.(float64)
-						}
-						if skey == "readyReplicas" {
-							readyReplicas = svalue.(float64)
-						}
-						if skey == "availableReplicas" {
-							availableReplicas = svalue.(float64)
-						}
-					}
-					// Trying to be completely sure that we can set READY status
-					if replicas > 0 {
-						if replicas == availableReplicas && replicas == readyReplicas {
-							metaDataRef.Status = "Ready"
-						}
-					}
-					statusProcessed = true
-				}
-				if statusKeyExists {
-					if metadataProcessed && statusProcessed {
-						metaDataSlice = append(metaDataSlice, metaDataRef)
-					}
-				} else if metadataProcessed {
-					metaDataSlice = append(metaDataSlice, metaDataRef)
-				}
+			metadataMap, ok := itemConverted["metadata"].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			metaDataRef := MetaDataAndOwnerReferences{}
+			parseObjectMeta(metadataMap, &metaDataRef)
+			health := evaluateHealth(resourceKind, itemConverted)
+			metaDataRef.Status = health.Status
+			metaDataRef.StatusReason = health.Reason
+			metaDataRef.Requests, metaDataRef.Limits = parseResources(resourceKind, itemConverted)
+			if resourceKind == POD {
+				metaDataRef.Containers = parseContainerImages(itemConverted)
+				metaDataRef.Diagnostics = parseContainerDiagnostics(itemConverted)
 			}
+			metaDataSlice = append(metaDataSlice, metaDataRef)
 		}
 	}
 	//fmt.Println("Exiting parseMetaData")
 	//fmt.Printf("Metadata slice:%v\n", metaDataSlice)
-	return metaDataSlice
+	return metaDataSlice, nil
 }
 
-func filterChildren(metaDataSlice *[]MetaDataAndOwnerReferences, parentResourceName string) []MetaDataAndOwnerReferences {
-	metaDataSliceToReturn := []MetaDataAndOwnerReferences{}
-	for _, metaDataRef := range *metaDataSlice {
-		if metaDataRef.OwnerReferenceName == parentResourceName {
-			// Prevent duplicates
-			present := false
-			for _, node := range metaDataSliceToReturn {
-				if node.MetaDataName == metaDataRef.MetaDataName {
-					present = true
-				}
-			}
-			if !present {
-				metaDataSliceToReturn = append(metaDataSliceToReturn, metaDataRef)
-			}
+func parseObjectMeta(metadataMap map[string]interface{}, metaDataRef *MetaDataAndOwnerReferences) {
+	metaDataRef.MetaDataName, _ = metadataMap["name"].(string)
+	metaDataRef.Namespace, _ = metadataMap["namespace"].(string)
+	metaDataRef.UID, _ = metadataMap["uid"].(string)
+	metaDataRef.ResourceVersion, _ = metadataMap["resourceVersion"].(string)
+	metaDataRef.CreationTimestamp, _ = metadataMap["creationTimestamp"].(string)
+	metaDataRef.DeletionTimestamp, _ = metadataMap["deletionTimestamp"].(string)
+	metaDataRef.Labels = parseStringMap(metadataMap["labels"])
+	metaDataRef.Annotations = parseStringMap(metadataMap["annotations"])
+	ownerReferencesList, _ := metadataMap["ownerReferences"].([]interface{})
+	for _, ownerReference := range ownerReferencesList {
+		ownerReferenceMap, ok := ownerReference.(map[string]interface{})
+		if !ok {
+			continue
 		}
+		metaDataRef.OwnerReferenceName, _ = ownerReferenceMap["name"].(string)
+		metaDataRef.OwnerReferenceKind, _ = ownerReferenceMap["kind"].(string)
+		metaDataRef.OwnerReferenceAPIVersion, _ = ownerReferenceMap["apiVersion"].(string)
 	}
-	return metaDataSliceToReturn
 }
 
-func parseNamespacesResponse(content []byte) []string {
-	var result map[string]interface{}
-	json.Unmarshal([]byte(content), &result)
-	namespaces := make([]string, 0)
-	items, ok := result["items"].([]interface{})
-
-	if ok {
-		for _, item := range items {
-			itemConverted := item.(map[string]interface{})
-			for key, value := range itemConverted {
-				if key == "metadata" {
-					metadataMap := value.(map[string]interface{})
-					for mkey, mvalue := range metadataMap {
-						if mkey == "name" {
-							namespace := mvalue.(string)
-							namespaces = append(namespaces, namespace)
-						}
-					}
-				}
-			}
-		}
+func parseStringMap(value interface{}) map[string]string {
+	valueMap, ok := value.(map[string]interface{})
+	if !ok || len(valueMap) == 0 {
+		return nil
 	}
-	return namespaces
-}
-
-// Ref:https://stackoverflow.com/questions/30690186/how-do-i-access-the-kubernetes-api-from-within-a-pod-container
-func getToken() []byte {
-	caToken, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/token")
-	if err != nil {
-		panic(err) // cannot find token file
+	stringMap := make(map[string]string, len(valueMap))
+	for key, elem := range valueMap {
+		stringMap[key], _ = elem.(string)
 	}
-	//fmt.Printf("Token:%s", caToken)
-	return caToken
+	return stringMap
 }
 
-// Ref:https://stackoverflow.com/questions/30690186/how-do-i-access-the-kubernetes-api-from-within-a-pod-container
-func getCACert() *cert.CertPool {
-	caCertPool := cert.NewCertPool()
-	caCert, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/ca.crt")
-	if err != nil {
-		panic(err) // Can't find cert file
+func contains(list []string, value string) bool {
+	for _, elem := range list {
+		if elem == value {
+			return true
+		}
 	}
-	//fmt.Printf("CaCert:%s",caCert)
-	caCertPool.AppendCertsFromPEM(caCert)
-	return caCertPool
+	return false
 }
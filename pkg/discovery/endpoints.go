@@ -0,0 +1,143 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Label set on EndpointSlices to the name of the Service they belong to
+const serviceNameLabel = "kubernetes.io/service-name"
+
+// Used to store the readiness of the endpoints of a Service
+type ServiceEndpoints struct {
+	Ready    int
+	NotReady int
+	// Pods targeted by the endpoints
+	Pods []string `json:",omitempty"`
+}
+
+// getServiceEndpoints returns the endpoints of all the Services in the namespace, keyed
+// by Service name. EndpointSlices are used if they are served by the API server,
+// otherwise Endpoints. Services without endpoints (e.g. ExternalName Services) are not
+// included.
+func getServiceEndpoints(namespace string) (map[string]ServiceEndpoints, error) {
+	if _, found := kindResolver.resolve("EndpointSlice", "endpointslices"); found {
+		return listServiceEndpoints("EndpointSlice", namespace, parseEndpointSlices)
+	}
+	return listServiceEndpoints("Endpoints", namespace, parseEndpoints)
+}
+
+func listServiceEndpoints(resourceKind, namespace string,
+	parse func(items []interface{}) map[string]ServiceEndpoints) (map[string]ServiceEndpoints, error) {
+	path, err := getResourcePath(resourceKind, namespace)
+	if err != nil {
+		return nil, err
+	}
+	content, err := queryAPIServer(path, false)
+	if err != nil {
+		return nil, err
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal(content, &result); err != nil {
+		return nil, fmt.Errorf("could not parse list: %s", err.Error())
+	}
+	items, _ := result["items"].([]interface{})
+	return parse(items), nil
+}
+
+func parseEndpoints(items []interface{}) map[string]ServiceEndpoints {
+	serviceEndpoints := make(map[string]ServiceEndpoints)
+	for _, item := range items {
+		itemMap, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		endpoints := ServiceEndpoints{}
+		for _, subset := range nestedSlice(itemMap, "subsets") {
+			subsetMap, ok := subset.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			for _, address := range nestedSlice(subsetMap, "addresses") {
+				endpoints.Ready++
+				endpoints.addPod(address)
+			}
+			for _, address := range nestedSlice(subsetMap, "notReadyAddresses") {
+				endpoints.NotReady++
+				endpoints.addPod(address)
+			}
+		}
+		sort.Strings(endpoints.Pods)
+		serviceEndpoints[nestedString(itemMap, "metadata", "name")] = endpoints
+	}
+	return serviceEndpoints
+}
+
+func parseEndpointSlices(items []interface{}) map[string]ServiceEndpoints {
+	serviceEndpoints := make(map[string]ServiceEndpoints)
+	// A Service can have several slices (e.g. one per address type), so endpoints are
+	// counted once per address
+	seenAddresses := make(map[string][]string)
+	for _, item := range items {
+		itemMap, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		serviceName := nestedString(itemMap, "metadata", "labels", serviceNameLabel)
+		if serviceName == "" {
+			continue
+		}
+		endpoints := serviceEndpoints[serviceName]
+		for _, endpoint := range nestedSlice(itemMap, "endpoints") {
+			endpointMap, ok := endpoint.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			addresses := nestedSlice(endpointMap, "addresses")
+			address := ""
+			if len(addresses) > 0 {
+				address, _ = addresses[0].(string)
+			}
+			if address != "" {
+				if contains(seenAddresses[serviceName], address) {
+					continue
+				}
+				seenAddresses[serviceName] = append(seenAddresses[serviceName], address)
+			}
+			// A missing ready condition means the endpoint is ready
+			if ready, found := nestedField(endpointMap, "conditions", "ready"); !found || ready == true {
+				endpoints.Ready++
+			} else {
+				endpoints.NotReady++
+			}
+			endpoints.addPod(endpoint)
+		}
+		sort.Strings(endpoints.Pods)
+		serviceEndpoints[serviceName] = endpoints
+	}
+	return serviceEndpoints
+}
+
+// addPod records the Pod targeted by the endpoint address, if any.
+func (e *ServiceEndpoints) addPod(address interface{}) {
+	addressMap, ok := address.(map[string]interface{})
+	if !ok || nestedString(addressMap, "targetRef", "kind") != POD {
+		return
+	}
+	podName := nestedString(addressMap, "targetRef", "name")
+	if podName != "" && !contains(e.Pods, podName) {
+		e.Pods = append(e.Pods, podName)
+	}
+}
+
+// endpointsHealth returns the health of a Service from the readiness of its endpoints.
+func endpointsHealth(endpoints ServiceEndpoints) Health {
+	if endpoints.Ready == 0 {
+		return Health{HEALTH_FAILED, fmt.Sprintf("no ready endpoints (%d not ready)", endpoints.NotReady)}
+	}
+	if endpoints.NotReady > 0 {
+		return Health{HEALTH_READY, fmt.Sprintf("%d of %d endpoints ready", endpoints.Ready, endpoints.Ready+endpoints.NotReady)}
+	}
+	return Health{Status: HEALTH_READY}
+}
@@ -0,0 +1,110 @@
+package discovery
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Maximum number of Events attached to a node; the most recent ones are kept
+const maxEventsPerNode = 10
+
+// Used for Final output of the Events of a resource
+type Event struct {
+	Type          string
+	Reason        string
+	Message       string
+	Count         int32
+	FirstSeen     string
+	LastSeen      string
+	Source        string `json:",omitempty"`
+	lastTimestamp time.Time
+}
+
+// attachEvents adds the recent Events of each node to the compositions. Events are
+// matched to nodes on the UID of their involvedObject and sorted by time, oldest first.
+// They are only attached if the caller is allowed to get Events in the namespace.
+func attachEvents(compositions []Composition, namespace string, accessChecker AccessChecker) error {
+	if accessChecker != nil && !accessChecker.CanGet("Event", namespace, "") {
+		return fmt.Errorf("not allowed to get events in ns %s", namespace)
+	}
+	client, err := getKubeClient()
+	if err != nil {
+		return err
+	}
+	eventList, err := client.CoreV1().Events(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("could not list events in ns %s: %s", namespace, err.Error())
+	}
+
+	eventsByUID := make(map[string][]Event)
+	for _, event := range eventList.Items {
+		uid := string(event.InvolvedObject.UID)
+		if uid == "" {
+			continue
+		}
+		eventsByUID[uid] = append(eventsByUID[uid], newEvent(event))
+	}
+	for uid := range eventsByUID {
+		events := eventsByUID[uid]
+		sort.SliceStable(events, func(i, j int) bool {
+			return events[i].lastTimestamp.Before(events[j].lastTimestamp)
+		})
+		if len(events) > maxEventsPerNode {
+			events = events[len(events)-maxEventsPerNode:]
+		}
+		eventsByUID[uid] = events
+	}
+	setEvents(compositions, eventsByUID)
+	return nil
+}
+
+func setEvents(compositions []Composition, eventsByUID map[string][]Event) {
+	for i := range compositions {
+		composition := &compositions[i]
+		if composition.UID != "" {
+			composition.Events = eventsByUID[composition.UID]
+		}
+		setEvents(composition.Children, eventsByUID)
+	}
+}
+
+func newEvent(event corev1.Event) Event {
+	firstTimestamp := event.FirstTimestamp.Time
+	lastTimestamp := event.LastTimestamp.Time
+	// Events created through the events.k8s.io API only set the eventTime
+	if lastTimestamp.IsZero() {
+		lastTimestamp = event.EventTime.Time
+	}
+	if firstTimestamp.IsZero() {
+		firstTimestamp = lastTimestamp
+	}
+	count := event.Count
+	if count == 0 {
+		count = 1
+	}
+	source := event.Source.Component
+	if source == "" {
+		source = event.ReportingController
+	}
+	return Event{
+		Type:          event.Type,
+		Reason:        event.Reason,
+		Message:       event.Message,
+		Count:         count,
+		FirstSeen:     formatTimestamp(firstTimestamp),
+		LastSeen:      formatTimestamp(lastTimestamp),
+		Source:        source,
+		lastTimestamp: lastTimestamp,
+	}
+}
+
+func formatTimestamp(timestamp time.Time) string {
+	if timestamp.IsZero() {
+		return ""
+	}
+	return timestamp.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,375 @@
+package discovery
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Health statuses reported for the resources in composition trees
+const (
+	HEALTH_READY       = "Ready"
+	HEALTH_PROGRESSING = "Progressing"
+	HEALTH_FAILED      = "Failed"
+)
+
+// Used to hold the health of a resource. Status is empty if the health cannot be
+// determined (e.g. the resource has no status).
+type Health struct {
+	Status string
+	Reason string
+}
+
+// Used to determine the health of a resource of a Kind from its complete object
+// (as returned by the main API server).
+type HealthEvaluator interface {
+	Evaluate(object map[string]interface{}) Health
+}
+
+// HealthEvaluatorFunc allows ordinary functions to be used as HealthEvaluators.
+type HealthEvaluatorFunc func(object map[string]interface{}) Health
+
+func (f HealthEvaluatorFunc) Evaluate(object map[string]interface{}) Health {
+	return f(object)
+}
+
+var (
+	healthEvaluators = map[string]HealthEvaluator{
+		"Deployment":  HealthEvaluatorFunc(deploymentHealth),
+		"Pod":         HealthEvaluatorFunc(podHealth),
+		"Job":         HealthEvaluatorFunc(jobHealth),
+		"StatefulSet": HealthEvaluatorFunc(statefulSetHealth),
+		"DaemonSet":   HealthEvaluatorFunc(daemonSetHealth),
+	}
+	healthEvaluatorsMux sync.RWMutex
+)
+
+// Waiting reasons of containers that will not resolve without intervention
+var failedWaitingReasons = []string{"CrashLoopBackOff", "ImagePullBackOff", "ErrImagePull",
+	"InvalidImageName", "CreateContainerConfigError", "CreateContainerError", "RunContainerError"}
+
+// RegisterHealthEvaluator sets the evaluator used for resources of the Kind,
+// replacing the built-in one if any.
+func RegisterHealthEvaluator(resourceKind string, evaluator HealthEvaluator) {
+	healthEvaluatorsMux.Lock()
+	defer healthEvaluatorsMux.Unlock()
+	healthEvaluators[resourceKind] = evaluator
+}
+
+// evaluateHealth returns the health of the object using the status rule of its Kind from
+// the Kind registry, or else the evaluator registered for its Kind, falling back to the
+// generic conditions based evaluation.
+func evaluateHealth(resourceKind string, object map[string]interface{}) Health {
+	if statusRule, present := getRegistry().statusRuleMap[resourceKind]; present {
+		return statusRule.Evaluate(object)
+	}
+	if _, ok := object["status"].(map[string]interface{}); !ok {
+		return Health{}
+	}
+	healthEvaluatorsMux.RLock()
+	evaluator, present := healthEvaluators[resourceKind]
+	healthEvaluatorsMux.RUnlock()
+	if !present {
+		evaluator = HealthEvaluatorFunc(genericHealth)
+	}
+	return evaluator.Evaluate(object)
+}
+
+func deploymentHealth(object map[string]interface{}) Health {
+	if health, observed := generationObserved(object); !observed {
+		return health
+	}
+	progressing, found := getCondition(object, "Progressing")
+	if found && progressing.status == "False" && progressing.reason == "ProgressDeadlineExceeded" {
+		return Health{HEALTH_FAILED, progressing.describe()}
+	}
+	replicas := nestedNumber(object, 1, "spec", "replicas")
+	updatedReplicas := nestedNumber(object, 0, "status", "updatedReplicas")
+	statusReplicas := nestedNumber(object, 0, "status", "replicas")
+	availableReplicas := nestedNumber(object, 0, "status", "availableReplicas")
+	switch {
+	case updatedReplicas < replicas:
+		return Health{HEALTH_PROGRESSING, fmt.Sprintf("%d of %d replicas updated", updatedReplicas, replicas)}
+	case statusReplicas > updatedReplicas:
+		return Health{HEALTH_PROGRESSING, fmt.Sprintf("%d old replicas pending termination", statusReplicas-updatedReplicas)}
+	case availableReplicas < updatedReplicas:
+		return Health{HEALTH_PROGRESSING, fmt.Sprintf("%d of %d updated replicas available", availableReplicas, updatedReplicas)}
+	}
+	if available, found := getCondition(object, "Available"); found && available.status == "False" {
+		return Health{HEALTH_FAILED, available.describe()}
+	}
+	return Health{Status: HEALTH_READY}
+}
+
+func podHealth(object map[string]interface{}) Health {
+	phase := nestedString(object, "status", "phase")
+	switch phase {
+	case "Succeeded":
+		return Health{Status: HEALTH_READY, Reason: "Completed"}
+	case "Failed":
+		return Health{HEALTH_FAILED, nestedString(object, "status", "reason")}
+	}
+	containerStatuses := []interface{}{}
+	containerStatuses = append(containerStatuses, nestedSlice(object, "status", "initContainerStatuses")...)
+	containerStatuses = append(containerStatuses, nestedSlice(object, "status", "containerStatuses")...)
+	for _, containerStatus := range containerStatuses {
+		containerStatusMap, ok := containerStatus.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		containerName := nestedString(containerStatusMap, "name")
+		waitingReason := nestedString(containerStatusMap, "state", "waiting", "reason")
+		if contains(failedWaitingReasons, waitingReason) {
+			return Health{HEALTH_FAILED, fmt.Sprintf("container %s: %s", containerName, waitingReason)}
+		}
+	}
+	if ready, found := getCondition(object, "Ready"); found && ready.status == "True" {
+		return Health{Status: HEALTH_READY}
+	}
+	for _, containerStatus := range containerStatuses {
+		containerStatusMap, ok := containerStatus.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if ready, _ := containerStatusMap["ready"].(bool); ready {
+			continue
+		}
+		containerName := nestedString(containerStatusMap, "name")
+		if reason := nestedString(containerStatusMap, "state", "waiting", "reason"); reason != "" {
+			return Health{HEALTH_PROGRESSING, fmt.Sprintf("container %s: %s", containerName, reason)}
+		}
+		if reason := nestedString(containerStatusMap, "state", "terminated", "reason"); reason != "" {
+			return Health{HEALTH_PROGRESSING, fmt.Sprintf("container %s: %s", containerName, reason)}
+		}
+		return Health{HEALTH_PROGRESSING, fmt.Sprintf("container %s not ready", containerName)}
+	}
+	if phase == "" {
+		return Health{}
+	}
+	return Health{HEALTH_PROGRESSING, phase}
+}
+
+func jobHealth(object map[string]interface{}) Health {
+	if failed, found := getCondition(object, "Failed"); found && failed.status == "True" {
+		return Health{HEALTH_FAILED, failed.describe()}
+	}
+	if complete, found := getCondition(object, "Complete"); found && complete.status == "True" {
+		return Health{Status: HEALTH_READY, Reason: "Completed"}
+	}
+	active := nestedNumber(object, 0, "status", "active")
+	succeeded := nestedNumber(object, 0, "status", "succeeded")
+	return Health{HEALTH_PROGRESSING, fmt.Sprintf("%d active, %d succeeded", active, succeeded)}
+}
+
+func statefulSetHealth(object map[string]interface{}) Health {
+	if health, observed := generationObserved(object); !observed {
+		return health
+	}
+	replicas := nestedNumber(object, 1, "spec", "replicas")
+	readyReplicas := nestedNumber(object, 0, "status", "readyReplicas")
+	if readyReplicas < replicas {
+		return Health{HEALTH_PROGRESSING, fmt.Sprintf("%d of %d replicas ready", readyReplicas, replicas)}
+	}
+	// Partitioned rolling updates are complete once the replicas above the partition are updated
+	if nestedString(object, "spec", "updateStrategy", "type") != "OnDelete" {
+		partition := nestedNumber(object, 0, "spec", "updateStrategy", "rollingUpdate", "partition")
+		updatedReplicas := nestedNumber(object, 0, "status", "updatedReplicas")
+		if updatedReplicas < replicas-partition {
+			return Health{HEALTH_PROGRESSING, fmt.Sprintf("%d of %d replicas updated", updatedReplicas, replicas-partition)}
+		}
+		if partition == 0 && nestedString(object, "status", "updateRevision") != nestedString(object, "status", "currentRevision") {
+			return Health{HEALTH_PROGRESSING, "rollout in progress"}
+		}
+	}
+	return Health{Status: HEALTH_READY}
+}
+
+func daemonSetHealth(object map[string]interface{}) Health {
+	if health, observed := generationObserved(object); !observed {
+		return health
+	}
+	desired := nestedNumber(object, 0, "status", "desiredNumberScheduled")
+	if nestedString(object, "spec", "updateStrategy", "type") != "OnDelete" {
+		updated := nestedNumber(object, 0, "status", "updatedNumberScheduled")
+		if updated < desired {
+			return Health{HEALTH_PROGRESSING, fmt.Sprintf("%d of %d pods updated", updated, desired)}
+		}
+	}
+	available := nestedNumber(object, 0, "status", "numberAvailable")
+	if available < desired {
+		return Health{HEALTH_PROGRESSING, fmt.Sprintf("%d of %d pods available", available, desired)}
+	}
+	return Health{Status: HEALTH_READY}
+}
+
+// genericHealth is used for Kinds without an evaluator of their own. It looks at the
+// standard conditions, then at status.phase and finally at the replica counts.
+func genericHealth(object map[string]interface{}) Health {
+	for _, conditionType := range []string{"Failed", "Degraded", "Stalled"} {
+		if condition, found := getCondition(object, conditionType); found && condition.status == "True" {
+			return Health{HEALTH_FAILED, condition.describe()}
+		}
+	}
+	for _, conditionType := range []string{"Ready", "Available"} {
+		if condition, found := getCondition(object, conditionType); found {
+			if condition.status == "True" {
+				return Health{Status: HEALTH_READY}
+			}
+			return Health{HEALTH_PROGRESSING, condition.describe()}
+		}
+	}
+	if condition, found := getCondition(object, "Complete"); found && condition.status == "True" {
+		return Health{Status: HEALTH_READY, Reason: "Completed"}
+	}
+
+	switch phase := nestedString(object, "status", "phase"); phase {
+	case "":
+	case "Running", "Bound", "Active", "Available", "Succeeded":
+		return Health{Status: HEALTH_READY, Reason: phase}
+	case "Failed", "Lost":
+		return Health{HEALTH_FAILED, phase}
+	default:
+		return Health{HEALTH_PROGRESSING, phase}
+	}
+
+	replicas := nestedNumber(object, 0, "status", "replicas")
+	readyReplicas := nestedNumber(object, 0, "status", "readyReplicas")
+	availableReplicas := nestedNumber(object, 0, "status", "availableReplicas")
+	if replicas > 0 {
+		if replicas == availableReplicas && replicas == readyReplicas {
+			return Health{Status: HEALTH_READY}
+		}
+		return Health{HEALTH_PROGRESSING, fmt.Sprintf("%d of %d replicas ready", readyReplicas, replicas)}
+	}
+	return Health{}
+}
+
+// healthSeverity orders the health statuses from best to worst. Resources whose
+// health is unknown do not affect the health of their ancestors.
+func healthSeverity(status string) int {
+	switch status {
+	case HEALTH_READY:
+		return 1
+	case HEALTH_PROGRESSING:
+		return 2
+	case HEALTH_FAILED:
+		return 3
+	}
+	return 0
+}
+
+// rollUpHealth sets the aggregated status of the composition and all of its descendants
+// to the worst health found in their subtrees. The reason names the descendant that
+// the aggregated status comes from, e.g. "Pod db-0: container db: CrashLoopBackOff".
+func rollUpHealth(composition *Composition) {
+	composition.AggregatedStatus = composition.Status
+	composition.AggregatedStatusReason = composition.StatusReason
+	for i := range composition.Children {
+		child := &composition.Children[i]
+		rollUpHealth(child)
+		if healthSeverity(child.AggregatedStatus) <= healthSeverity(composition.AggregatedStatus) {
+			continue
+		}
+		composition.AggregatedStatus = child.AggregatedStatus
+		if child.AggregatedStatus == child.Status && child.Name != "" {
+			// The child itself is responsible
+			composition.AggregatedStatusReason = child.Kind + " " + child.Name
+			if child.StatusReason != "" {
+				composition.AggregatedStatusReason = composition.AggregatedStatusReason + ": " + child.StatusReason
+			}
+		} else {
+			composition.AggregatedStatusReason = child.AggregatedStatusReason
+		}
+	}
+}
+
+// generationObserved returns false, along with the corresponding health, if the
+// controller has not yet observed the latest spec of the object.
+func generationObserved(object map[string]interface{}) (Health, bool) {
+	generation := nestedNumber(object, 0, "metadata", "generation")
+	observedGeneration := nestedNumber(object, 0, "status", "observedGeneration")
+	if observedGeneration < generation {
+		return Health{HEALTH_PROGRESSING, "waiting for the latest spec to be observed"}, false
+	}
+	return Health{}, true
+}
+
+// Used to hold the fields of a status condition that are needed for evaluating health
+type condition struct {
+	conditionType string
+	status        string
+	reason        string
+	message       string
+}
+
+func (c condition) describe() string {
+	parts := []string{}
+	for _, part := range []string{c.reason, c.message} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 {
+		return c.conditionType + "=" + c.status
+	}
+	return strings.Join(parts, ": ")
+}
+
+func getCondition(object map[string]interface{}, conditionType string) (condition, bool) {
+	for _, item := range nestedSlice(object, "status", "conditions") {
+		conditionMap, ok := item.(map[string]interface{})
+		if !ok || nestedString(conditionMap, "type") != conditionType {
+			continue
+		}
+		return condition{
+			conditionType: conditionType,
+			status:        nestedString(conditionMap, "status"),
+			reason:        nestedString(conditionMap, "reason"),
+			message:       nestedString(conditionMap, "message"),
+		}, true
+	}
+	return condition{}, false
+}
+
+func nestedField(object map[string]interface{}, fields ...string) (interface{}, bool) {
+	var current interface{} = object
+	for _, field := range fields {
+		currentMap, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = currentMap[field]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func nestedString(object map[string]interface{}, fields ...string) string {
+	value, _ := nestedField(object, fields...)
+	valueString, _ := value.(string)
+	return valueString
+}
+
+// nestedNumber returns the number at the given path, or defaultValue if it is not set.
+// Numbers in objects parsed with encoding/json are float64.
+func nestedNumber(object map[string]interface{}, defaultValue int64, fields ...string) int64 {
+	value, found := nestedField(object, fields...)
+	if !found {
+		return defaultValue
+	}
+	switch number := value.(type) {
+	case float64:
+		return int64(number)
+	case int64:
+		return number
+	}
+	return defaultValue
+}
+
+func nestedSlice(object map[string]interface{}, fields ...string) []interface{} {
+	value, _ := nestedField(object, fields...)
+	valueSlice, _ := value.([]interface{})
+	return valueSlice
+}
@@ -0,0 +1,278 @@
+package discovery
+
+import (
+	"testing"
+)
+
+func TestDeploymentHealth(t *testing.T) {
+	testCases := []struct {
+		name     string
+		object   map[string]interface{}
+		expected Health
+	}{
+		{
+			name: "ready",
+			object: map[string]interface{}{
+				"spec":   map[string]interface{}{"replicas": float64(2)},
+				"status": map[string]interface{}{"replicas": float64(2), "updatedReplicas": float64(2), "availableReplicas": float64(2)},
+			},
+			expected: Health{Status: HEALTH_READY},
+		},
+		{
+			name: "spec not observed",
+			object: map[string]interface{}{
+				"metadata": map[string]interface{}{"generation": float64(3)},
+				"spec":     map[string]interface{}{"replicas": float64(2)},
+				"status": map[string]interface{}{"observedGeneration": float64(2),
+					"replicas": float64(2), "updatedReplicas": float64(2), "availableReplicas": float64(2)},
+			},
+			expected: Health{HEALTH_PROGRESSING, "waiting for the latest spec to be observed"},
+		},
+		{
+			name: "replicas being updated",
+			object: map[string]interface{}{
+				"spec":   map[string]interface{}{"replicas": float64(3)},
+				"status": map[string]interface{}{"replicas": float64(3), "updatedReplicas": float64(1), "availableReplicas": float64(3)},
+			},
+			expected: Health{HEALTH_PROGRESSING, "1 of 3 replicas updated"},
+		},
+		{
+			name: "old replicas pending termination",
+			object: map[string]interface{}{
+				"spec":   map[string]interface{}{"replicas": float64(2)},
+				"status": map[string]interface{}{"replicas": float64(3), "updatedReplicas": float64(2), "availableReplicas": float64(2)},
+			},
+			expected: Health{HEALTH_PROGRESSING, "1 old replicas pending termination"},
+		},
+		{
+			name: "updated replicas not available",
+			object: map[string]interface{}{
+				"spec":   map[string]interface{}{"replicas": float64(2)},
+				"status": map[string]interface{}{"replicas": float64(2), "updatedReplicas": float64(2), "availableReplicas": float64(1)},
+			},
+			expected: Health{HEALTH_PROGRESSING, "1 of 2 updated replicas available"},
+		},
+		{
+			name: "replicas default to one",
+			object: map[string]interface{}{
+				"status": map[string]interface{}{"replicas": float64(0)},
+			},
+			expected: Health{HEALTH_PROGRESSING, "0 of 1 replicas updated"},
+		},
+		{
+			name: "progress deadline exceeded",
+			object: map[string]interface{}{
+				"spec": map[string]interface{}{"replicas": float64(2)},
+				"status": map[string]interface{}{"replicas": float64(2), "updatedReplicas": float64(1),
+					"conditions": []interface{}{
+						map[string]interface{}{"type": "Progressing", "status": "False", "reason": "ProgressDeadlineExceeded",
+							"message": "ReplicaSet \"web-5d8f\" has timed out progressing."},
+					}},
+			},
+			expected: Health{HEALTH_FAILED, "ProgressDeadlineExceeded: ReplicaSet \"web-5d8f\" has timed out progressing."},
+		},
+		{
+			name: "not available",
+			object: map[string]interface{}{
+				"spec": map[string]interface{}{"replicas": float64(2)},
+				"status": map[string]interface{}{"replicas": float64(2), "updatedReplicas": float64(2), "availableReplicas": float64(2),
+					"conditions": []interface{}{
+						map[string]interface{}{"type": "Available", "status": "False", "reason": "MinimumReplicasUnavailable"},
+					}},
+			},
+			expected: Health{HEALTH_FAILED, "MinimumReplicasUnavailable"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if health := deploymentHealth(testCase.object); health != testCase.expected {
+			t.Errorf("%s: expected %+v, got %+v", testCase.name, testCase.expected, health)
+		}
+	}
+}
+
+func TestStatefulSetHealth(t *testing.T) {
+	testCases := []struct {
+		name     string
+		object   map[string]interface{}
+		expected Health
+	}{
+		{
+			name: "ready",
+			object: map[string]interface{}{
+				"spec": map[string]interface{}{"replicas": float64(3)},
+				"status": map[string]interface{}{"readyReplicas": float64(3), "updatedReplicas": float64(3),
+					"currentRevision": "db-7f9c", "updateRevision": "db-7f9c"},
+			},
+			expected: Health{Status: HEALTH_READY},
+		},
+		{
+			name: "spec not observed",
+			object: map[string]interface{}{
+				"metadata": map[string]interface{}{"generation": float64(2)},
+				"spec":     map[string]interface{}{"replicas": float64(3)},
+				"status":   map[string]interface{}{"observedGeneration": float64(1), "readyReplicas": float64(3)},
+			},
+			expected: Health{HEALTH_PROGRESSING, "waiting for the latest spec to be observed"},
+		},
+		{
+			name: "replicas not ready",
+			object: map[string]interface{}{
+				"spec":   map[string]interface{}{"replicas": float64(3)},
+				"status": map[string]interface{}{"readyReplicas": float64(1)},
+			},
+			expected: Health{HEALTH_PROGRESSING, "1 of 3 replicas ready"},
+		},
+		{
+			name: "rolling update in progress",
+			object: map[string]interface{}{
+				"spec": map[string]interface{}{"replicas": float64(3)},
+				"status": map[string]interface{}{"readyReplicas": float64(3), "updatedReplicas": float64(1),
+					"currentRevision": "db-7f9c", "updateRevision": "db-8a1d"},
+			},
+			expected: Health{HEALTH_PROGRESSING, "1 of 3 replicas updated"},
+		},
+		{
+			name: "revision not yet current",
+			object: map[string]interface{}{
+				"spec": map[string]interface{}{"replicas": float64(3)},
+				"status": map[string]interface{}{"readyReplicas": float64(3), "updatedReplicas": float64(3),
+					"currentRevision": "db-7f9c", "updateRevision": "db-8a1d"},
+			},
+			expected: Health{HEALTH_PROGRESSING, "rollout in progress"},
+		},
+		{
+			name: "partitioned rolling update complete",
+			object: map[string]interface{}{
+				"spec": map[string]interface{}{"replicas": float64(3),
+					"updateStrategy": map[string]interface{}{"type": "RollingUpdate",
+						"rollingUpdate": map[string]interface{}{"partition": float64(2)}}},
+				"status": map[string]interface{}{"readyReplicas": float64(3), "updatedReplicas": float64(1),
+					"currentRevision": "db-7f9c", "updateRevision": "db-8a1d"},
+			},
+			expected: Health{Status: HEALTH_READY},
+		},
+		{
+			name: "partitioned rolling update in progress",
+			object: map[string]interface{}{
+				"spec": map[string]interface{}{"replicas": float64(3),
+					"updateStrategy": map[string]interface{}{"type": "RollingUpdate",
+						"rollingUpdate": map[string]interface{}{"partition": float64(1)}}},
+				"status": map[string]interface{}{"readyReplicas": float64(3), "updatedReplicas": float64(1),
+					"currentRevision": "db-7f9c", "updateRevision": "db-8a1d"},
+			},
+			expected: Health{HEALTH_PROGRESSING, "1 of 2 replicas updated"},
+		},
+		{
+			name: "OnDelete updates are not tracked",
+			object: map[string]interface{}{
+				"spec": map[string]interface{}{"replicas": float64(3),
+					"updateStrategy": map[string]interface{}{"type": "OnDelete"}},
+				"status": map[string]interface{}{"readyReplicas": float64(3), "updatedReplicas": float64(0),
+					"currentRevision": "db-7f9c", "updateRevision": "db-8a1d"},
+			},
+			expected: Health{Status: HEALTH_READY},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if health := statefulSetHealth(testCase.object); health != testCase.expected {
+			t.Errorf("%s: expected %+v, got %+v", testCase.name, testCase.expected, health)
+		}
+	}
+}
+
+func TestPodHealth(t *testing.T) {
+	testCases := []struct {
+		name     string
+		object   map[string]interface{}
+		expected Health
+	}{
+		{
+			name: "ready",
+			object: map[string]interface{}{"status": map[string]interface{}{"phase": "Running",
+				"conditions": []interface{}{map[string]interface{}{"type": "Ready", "status": "True"}}}},
+			expected: Health{Status: HEALTH_READY},
+		},
+		{
+			name:     "completed",
+			object:   map[string]interface{}{"status": map[string]interface{}{"phase": "Succeeded"}},
+			expected: Health{Status: HEALTH_READY, Reason: "Completed"},
+		},
+		{
+			name:     "failed",
+			object:   map[string]interface{}{"status": map[string]interface{}{"phase": "Failed", "reason": "Evicted"}},
+			expected: Health{HEALTH_FAILED, "Evicted"},
+		},
+		{
+			name: "crash looping",
+			object: map[string]interface{}{"status": map[string]interface{}{"phase": "Running",
+				"containerStatuses": []interface{}{map[string]interface{}{"name": "web",
+					"state": map[string]interface{}{"waiting": map[string]interface{}{"reason": "CrashLoopBackOff"}}}}}},
+			expected: Health{HEALTH_FAILED, "container web: CrashLoopBackOff"},
+		},
+		{
+			name: "container creating",
+			object: map[string]interface{}{"status": map[string]interface{}{"phase": "Pending",
+				"containerStatuses": []interface{}{map[string]interface{}{"name": "web",
+					"state": map[string]interface{}{"waiting": map[string]interface{}{"reason": "ContainerCreating"}}}}}},
+			expected: Health{HEALTH_PROGRESSING, "container web: ContainerCreating"},
+		},
+		{
+			name:     "pending",
+			object:   map[string]interface{}{"status": map[string]interface{}{"phase": "Pending"}},
+			expected: Health{HEALTH_PROGRESSING, "Pending"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if health := podHealth(testCase.object); health != testCase.expected {
+			t.Errorf("%s: expected %+v, got %+v", testCase.name, testCase.expected, health)
+		}
+	}
+}
+
+func TestGenericHealth(t *testing.T) {
+	testCases := []struct {
+		name     string
+		object   map[string]interface{}
+		expected Health
+	}{
+		{
+			name: "failed condition",
+			object: map[string]interface{}{"status": map[string]interface{}{"conditions": []interface{}{
+				map[string]interface{}{"type": "Ready", "status": "True"},
+				map[string]interface{}{"type": "Degraded", "status": "True", "reason": "BackupFailed"},
+			}}},
+			expected: Health{HEALTH_FAILED, "BackupFailed"},
+		},
+		{
+			name: "ready condition false",
+			object: map[string]interface{}{"status": map[string]interface{}{"conditions": []interface{}{
+				map[string]interface{}{"type": "Ready", "status": "False"},
+			}}},
+			expected: Health{HEALTH_PROGRESSING, "Ready=False"},
+		},
+		{
+			name:     "phase",
+			object:   map[string]interface{}{"status": map[string]interface{}{"phase": "Bound"}},
+			expected: Health{Status: HEALTH_READY, Reason: "Bound"},
+		},
+		{
+			name:     "replicas not ready",
+			object:   map[string]interface{}{"status": map[string]interface{}{"replicas": float64(2), "readyReplicas": float64(1)}},
+			expected: Health{HEALTH_PROGRESSING, "1 of 2 replicas ready"},
+		},
+		{
+			name:     "unknown",
+			object:   map[string]interface{}{"status": map[string]interface{}{}},
+			expected: Health{},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if health := genericHealth(testCase.object); health != testCase.expected {
+			t.Errorf("%s: expected %+v, got %+v", testCase.name, testCase.expected, health)
+		}
+	}
+}
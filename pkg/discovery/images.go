@@ -0,0 +1,134 @@
+package discovery
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// Used to store the image of a container of a Pod
+type ContainerImage struct {
+	Pod       string
+	Container string
+	Init      bool `json:",omitempty"`
+	Image     string
+	Digest    string `json:",omitempty"`
+}
+
+// Used for Final output of the image inventory. Each entry lists the containers
+// running the image and the top-level instances they belong to.
+type ImageInventory struct {
+	Image      string
+	Digests    []string `json:",omitempty"`
+	Instances  []string
+	Containers []ContainerImage
+}
+
+// parseContainerImages returns the images of the containers and init containers of
+// the Pod. Digests are taken from the image reference or, if it is not pinned,
+// from the imageID reported in the container statuses.
+func parseContainerImages(object map[string]interface{}) []ContainerImage {
+	podName := nestedString(object, "metadata", "name")
+	imageIDs := make(map[string]string)
+	for _, statusField := range []string{"initContainerStatuses", "containerStatuses"} {
+		for _, containerStatus := range nestedSlice(object, "status", statusField) {
+			if containerStatusMap, ok := containerStatus.(map[string]interface{}); ok {
+				imageIDs[nestedString(containerStatusMap, "name")] = nestedString(containerStatusMap, "imageID")
+			}
+		}
+	}
+
+	containerImages := []ContainerImage{}
+	for _, specField := range []string{"initContainers", "containers"} {
+		for _, container := range nestedSlice(object, "spec", specField) {
+			containerMap, ok := container.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			containerName := nestedString(containerMap, "name")
+			image := nestedString(containerMap, "image")
+			digest := imageDigest(image)
+			if digest == "" {
+				digest = imageDigest(imageIDs[containerName])
+			}
+			containerImages = append(containerImages, ContainerImage{
+				Pod:       podName,
+				Container: containerName,
+				Init:      specField == "initContainers",
+				Image:     image,
+				Digest:    digest,
+			})
+		}
+	}
+	return containerImages
+}
+
+// imageDigest returns the digest part of an image reference or imageID,
+// e.g. sha256:... of docker-pullable://nginx@sha256:...
+func imageDigest(image string) string {
+	if index := strings.LastIndex(image, "@"); index >= 0 {
+		return image[index+1:]
+	}
+	if strings.HasPrefix(image, "sha256:") {
+		return image
+	}
+	return ""
+}
+
+// GetImages returns the inventory of the images run by the Pods in the composition
+// trees of the requested instance(s) as JSON, grouped by image.
+func (cp *ClusterCompositions) GetImages(resourceKind, resourceName, namespace string,
+	accessChecker AccessChecker) (string, error) {
+	compositions, err := cp.getCompositionList(resourceKind, resourceName, namespace)
+	if err != nil {
+		return "", err
+	}
+	if accessChecker != nil {
+		compositions = filterCompositions(compositions, accessChecker)
+	}
+
+	inventory := make(map[string]*ImageInventory)
+	for _, composition := range compositions {
+		collectImages(composition, composition.Name, inventory)
+	}
+	images := []ImageInventory{}
+	for _, imageInventory := range inventory {
+		sort.Strings(imageInventory.Digests)
+		sort.Strings(imageInventory.Instances)
+		images = append(images, *imageInventory)
+	}
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].Image < images[j].Image
+	})
+
+	imagesBytes, err := json.Marshal(images)
+	if err != nil {
+		return "", err
+	}
+	return string(imagesBytes), nil
+}
+
+func collectImages(composition Composition, instance string, inventory map[string]*ImageInventory) {
+	for _, containerImage := range composition.containers {
+		imageInventory, present := inventory[containerImage.Image]
+		if !present {
+			imageInventory = &ImageInventory{
+				Image:      containerImage.Image,
+				Digests:    []string{},
+				Instances:  []string{},
+				Containers: []ContainerImage{},
+			}
+			inventory[containerImage.Image] = imageInventory
+		}
+		if containerImage.Digest != "" && !contains(imageInventory.Digests, containerImage.Digest) {
+			imageInventory.Digests = append(imageInventory.Digests, containerImage.Digest)
+		}
+		if !contains(imageInventory.Instances, instance) {
+			imageInventory.Instances = append(imageInventory.Instances, instance)
+		}
+		imageInventory.Containers = append(imageInventory.Containers, containerImage)
+	}
+	for _, child := range composition.Children {
+		collectImages(child, instance, inventory)
+	}
+}
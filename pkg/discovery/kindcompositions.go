@@ -0,0 +1,214 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Cluster-scoped KindComposition objects hold entries of the Kind registry,
+// see artifacts/example/kindcomposition-crd.yaml
+const (
+	KIND_COMPOSITION_ACCEPTED = "Accepted"
+	KIND_COMPOSITION_CONFLICT = "Conflict"
+	KIND_COMPOSITION_INVALID  = "Invalid"
+)
+
+// Used to store the status reported on a KindComposition object. The message is always
+// written so that merge patches clear the message of an earlier state.
+type kindCompositionStatus struct {
+	State   string `json:"state"`
+	Message string `json:"message"`
+}
+
+// Used to hold a KindComposition object
+type kindCompositionObject struct {
+	name              string
+	creationTimestamp string
+	entry             composition
+	// Set if the spec of the object could not be parsed
+	parseError string
+	// Status of the object as last listed
+	status kindCompositionStatus
+}
+
+var (
+	// KindComposition objects, oldest first
+	kindCompositionObjects []kindCompositionObject
+)
+
+// watchKindCompositions merges the KindComposition objects into the Kind registry
+// whenever one of them is added, changed or deleted.
+func watchKindCompositions() {
+	watchList(loadKindCompositions)
+}
+
+// getKindCompositionPath returns the path of the KindComposition objects at the version
+// served by the API server. It returns false if the KindComposition CRD is not installed.
+func getKindCompositionPath() (string, bool, error) {
+	return getClusterResourcePath("KindComposition", "kindcompositions")
+}
+
+// loadKindCompositions lists the KindComposition objects and merges them into the Kind
+// registry. It returns the path and resourceVersion of the list from which changes can
+// be watched.
+func loadKindCompositions() (string, string, error) {
+	path, found, err := getKindCompositionPath()
+	if err != nil {
+		return "", "", fmt.Errorf("could not resolve KindCompositions: %s", err.Error())
+	}
+	if !found {
+		// The KindComposition CRD is not installed; check again later
+		setKindCompositions(nil)
+		return "", "", nil
+	}
+	content, err := queryAPIServer(path, false)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			// The KindComposition CRD was removed or its served version changed
+			kindResolver.invalidate()
+			setKindCompositions(nil)
+			return "", "", nil
+		}
+		return "", "", fmt.Errorf("could not list KindCompositions: %s", err.Error())
+	}
+	var kindCompositionList map[string]interface{}
+	if err := json.Unmarshal(content, &kindCompositionList); err != nil {
+		return "", "", fmt.Errorf("could not parse KindCompositions: %s", err.Error())
+	}
+	objects := []kindCompositionObject{}
+	for _, item := range nestedSlice(kindCompositionList, "items") {
+		if itemMap, ok := item.(map[string]interface{}); ok {
+			objects = append(objects, parseKindComposition(itemMap))
+		}
+	}
+	// The oldest object declaring a Kind takes precedence
+	sort.SliceStable(objects, func(i, j int) bool {
+		if objects[i].creationTimestamp != objects[j].creationTimestamp {
+			return objects[i].creationTimestamp < objects[j].creationTimestamp
+		}
+		return objects[i].name < objects[j].name
+	})
+	setKindCompositions(objects)
+	return path, nestedString(kindCompositionList, "metadata", "resourceVersion"), nil
+}
+
+func parseKindComposition(object map[string]interface{}) kindCompositionObject {
+	kindComposition := kindCompositionObject{
+		name:              nestedString(object, "metadata", "name"),
+		creationTimestamp: nestedString(object, "metadata", "creationTimestamp"),
+		status: kindCompositionStatus{
+			State:   nestedString(object, "status", "state"),
+			Message: nestedString(object, "status", "message"),
+		},
+	}
+	spec, ok := object["spec"].(map[string]interface{})
+	if !ok {
+		kindComposition.parseError = "spec is not set"
+		return kindComposition
+	}
+	entry := composition{
+		Kind:        nestedString(spec, "kind"),
+		Plural:      nestedString(spec, "plural"),
+		Endpoint:    nestedString(spec, "endpoint"),
+		Composition: parseValueList(spec["composition"]),
+	}
+	entry.MetadataOnly, _ = spec["metadataOnly"].(bool)
+	if statusRuleMap, ok := spec["status"].(map[string]interface{}); ok {
+		entry.Status = parseStatusRule(statusRuleMap)
+	}
+	if openAPISpecMap, ok := spec["openapispec"].(map[string]interface{}); ok {
+		entry.OpenAPISpec = parseOpenAPISpecLocation(openAPISpecMap)
+	}
+	kindComposition.entry = entry
+	return kindComposition
+}
+
+// mergeKindCompositions returns the registry with the entries of the KindComposition
+// objects merged on top of the base registry, along with the status of each object.
+// An object is not merged if it is invalid, if an older object declares the same Kind,
+// or if its entry would introduce a cycle in the composition hierarchy. Child Kinds that
+// are not declared are resolved through the discovery API, as for CRD annotations.
+func mergeKindCompositions(base *kindRegistry, objects []kindCompositionObject) (*kindRegistry, map[string]kindCompositionStatus) {
+	registry := base
+	statuses := make(map[string]kindCompositionStatus)
+	declaredBy := make(map[string]string)
+	for _, object := range objects {
+		if object.parseError != "" {
+			statuses[object.name] = kindCompositionStatus{KIND_COMPOSITION_INVALID, object.parseError}
+			continue
+		}
+		kind := object.entry.Kind
+		if problems := validateComposition(object.entry); len(problems) > 0 {
+			statuses[object.name] = kindCompositionStatus{KIND_COMPOSITION_INVALID, strings.Join(problems, "; ")}
+			continue
+		}
+		if owner, present := declaredBy[kind]; present {
+			statuses[object.name] = kindCompositionStatus{KIND_COMPOSITION_CONFLICT,
+				fmt.Sprintf("Kind %s is already declared by KindComposition %s", kind, owner)}
+			continue
+		}
+		previousLayer, overrides := registry.layerMap[kind]
+		previousSource := registry.sourceMap[kind]
+		candidate := registry.copy()
+		err := candidate.register(object.entry, LAYER_KIND_COMPOSITION, object.name)
+		if err == nil {
+			candidate.declareChildKinds()
+			if problems := candidate.validate(); len(problems) > 0 {
+				err = fmt.Errorf("%s", strings.Join(problems, "; "))
+			}
+		}
+		if err != nil {
+			statuses[object.name] = kindCompositionStatus{KIND_COMPOSITION_INVALID, err.Error()}
+			continue
+		}
+		registry = candidate
+		declaredBy[kind] = object.name
+		status := kindCompositionStatus{State: KIND_COMPOSITION_ACCEPTED}
+		if overrides {
+			status.Message = fmt.Sprintf("replaces the entry of Kind %s from the %s layer", kind,
+				describeLayer(previousLayer, previousSource))
+		}
+		statuses[object.name] = status
+	}
+	return registry, statuses
+}
+
+// updateKindCompositionStatuses writes the statuses of the objects that changed.
+func updateKindCompositionStatuses(objects []kindCompositionObject, statuses map[string]kindCompositionStatus) {
+	client, err := getKubeClient()
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return
+	}
+	path, found, err := getKindCompositionPath()
+	if err == nil && !found {
+		err = fmt.Errorf("KindCompositions are not served by the API server")
+	}
+	if err != nil {
+		fmt.Printf("Error: could not update status of KindCompositions: %s\n", err.Error())
+		return
+	}
+	for _, object := range objects {
+		status, present := statuses[object.name]
+		if !present || status == object.status {
+			continue
+		}
+		patch, err := json.Marshal(map[string]interface{}{"status": status})
+		if err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			continue
+		}
+		_, err = client.CoreV1().RESTClient().Patch(types.MergePatchType).
+			AbsPath(path, object.name, "status").
+			Body(patch).
+			DoRaw()
+		if err != nil {
+			fmt.Printf("Error: could not update status of KindComposition %s: %s\n", object.name, err.Error())
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package discovery
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeKindCompositions(t *testing.T) {
+	postgres := kindCompositionObject{name: "postgres",
+		entry: composition{Kind: "Postgres", Plural: "postgreses", Composition: []string{"Deployment", "PgBackup"}}}
+	postgresTeam2 := kindCompositionObject{name: "pg-team2",
+		entry: composition{Kind: "Postgres", Plural: "postgreses", Composition: []string{"Service"}}}
+	pgBackup := kindCompositionObject{name: "pgbackup",
+		entry: composition{Kind: "PgBackup", Plural: "pgbackups", Composition: []string{"Postgres"}}}
+	pod := kindCompositionObject{name: "pod",
+		entry: composition{Kind: "Pod", Plural: "pods", Endpoint: "api/v1"}}
+
+	testCases := []struct {
+		name     string
+		objects  []kindCompositionObject
+		expected map[string]kindCompositionStatus
+		// Layer of each Kind in the merged registry
+		layers map[string]string
+	}{
+		{
+			name:     "accepted",
+			objects:  []kindCompositionObject{postgres},
+			expected: map[string]kindCompositionStatus{"postgres": {KIND_COMPOSITION_ACCEPTED, ""}},
+			layers:   map[string]string{"Postgres": LAYER_KIND_COMPOSITION, "PgBackup": LAYER_DISCOVERY},
+		},
+		{
+			name:    "replaces built-in entry",
+			objects: []kindCompositionObject{pod},
+			expected: map[string]kindCompositionStatus{"pod": {KIND_COMPOSITION_ACCEPTED,
+				"replaces the entry of Kind Pod from the built-in layer"}},
+			layers: map[string]string{"Pod": LAYER_KIND_COMPOSITION},
+		},
+		{
+			name:    "oldest object wins",
+			objects: []kindCompositionObject{postgres, postgresTeam2},
+			expected: map[string]kindCompositionStatus{
+				"postgres": {KIND_COMPOSITION_ACCEPTED, ""},
+				"pg-team2": {KIND_COMPOSITION_CONFLICT, "Kind Postgres is already declared by KindComposition postgres"},
+			},
+			layers: map[string]string{"Postgres": LAYER_KIND_COMPOSITION},
+		},
+		{
+			name:    "object introducing a cycle",
+			objects: []kindCompositionObject{postgres, pgBackup},
+			expected: map[string]kindCompositionStatus{
+				"postgres": {KIND_COMPOSITION_ACCEPTED, ""},
+				"pgbackup": {KIND_COMPOSITION_INVALID, "composition of PgBackup has a cycle: PgBackup -> Postgres -> PgBackup"},
+			},
+			layers: map[string]string{"Postgres": LAYER_KIND_COMPOSITION, "PgBackup": LAYER_DISCOVERY},
+		},
+		{
+			name: "spec not set",
+			objects: []kindCompositionObject{
+				{name: "empty", parseError: "spec is not set"},
+			},
+			expected: map[string]kindCompositionStatus{"empty": {KIND_COMPOSITION_INVALID, "spec is not set"}},
+		},
+		{
+			name: "invalid entry",
+			objects: []kindCompositionObject{
+				{name: "postgres", entry: composition{Kind: "Postgres", Endpoint: "postgres/v1"}},
+				postgresTeam2,
+			},
+			expected: map[string]kindCompositionStatus{
+				"postgres": {KIND_COMPOSITION_INVALID, "plural of Postgres is not set; " +
+					"endpoint postgres/v1 of Postgres is not of the form api/<version> or apis/<group>/<version>"},
+				"pg-team2": {KIND_COMPOSITION_ACCEPTED, ""},
+			},
+			layers: map[string]string{"Postgres": LAYER_KIND_COMPOSITION},
+		},
+		{
+			name: "invalid status rule",
+			objects: []kindCompositionObject{
+				{name: "postgres", entry: composition{Kind: "Postgres", Plural: "postgreses",
+					Status: StatusRule{Path: "{.status"}}},
+			},
+			expected: map[string]kindCompositionStatus{"postgres": {KIND_COMPOSITION_INVALID,
+				"status rule of Postgres: invalid JSONPath {.status: unclosed action"}},
+		},
+	}
+
+	for _, testCase := range testCases {
+		base := builtInKindRegistry()
+		registry, statuses := mergeKindCompositions(base, testCase.objects)
+		if !reflect.DeepEqual(statuses, testCase.expected) {
+			t.Errorf("%s: expected statuses %v, got %v", testCase.name, testCase.expected, statuses)
+		}
+		for kind, layer := range testCase.layers {
+			if registry.layerMap[kind] != layer {
+				t.Errorf("%s: expected %s from the %s layer, got %s", testCase.name, kind, layer, registry.layerMap[kind])
+			}
+		}
+		if _, present := base.layerMap["Postgres"]; present {
+			t.Errorf("%s: the base registry was modified", testCase.name)
+		}
+	}
+}
+
+func TestMergeKindCompositionsKeepsOlderEntry(t *testing.T) {
+	objects := []kindCompositionObject{
+		{name: "postgres", entry: composition{Kind: "Postgres", Plural: "postgreses", Composition: []string{"Deployment"}}},
+		{name: "pg-team2", entry: composition{Kind: "Postgres", Plural: "postgreses", Composition: []string{"Service"}}},
+	}
+	registry, _ := mergeKindCompositions(builtInKindRegistry(), objects)
+	if composition := registry.compositionMap["Postgres"]; !reflect.DeepEqual(composition, []string{"Deployment"}) {
+		t.Errorf("expected the composition of the oldest object, got %q", composition)
+	}
+	if source := registry.sourceMap["Postgres"]; source != "postgres" {
+		t.Errorf("expected KindComposition postgres as source, got %s", source)
+	}
+}
@@ -0,0 +1,67 @@
+package discovery
+
+import (
+	"encoding/json"
+)
+
+// Used for Final output of the Kind registry. Each entry shows the effective value of a
+// Kind and the layer of the registry it came from.
+type KindRegistryEntry struct {
+	Kind string
+	// Plural and apiVersion through which the Kind is queried, including resolved values
+	Plural      string
+	APIVersion  string `json:",omitempty"`
+	Endpoint    string `json:",omitempty"`
+	Composition []string
+	// Set for Kinds that are listed as PartialObjectMetadataList
+	MetadataOnly bool                 `json:",omitempty"`
+	StatusRule   *StatusRule          `json:",omitempty"`
+	OpenAPISpec  *OpenAPISpecLocation `json:",omitempty"`
+	Layer        string
+	// The file, CRD or KindComposition object defining the entry
+	Source string `json:",omitempty"`
+	// Layers whose entries of the Kind are replaced by this one
+	Overrides []string `json:",omitempty"`
+}
+
+// Used for Final output of the 'kinds' endpoint
+type KindRegistryInfo struct {
+	Kinds []KindRegistryEntry
+	// Set if layers could not be reloaded and their last good entries are in use
+	RegistryError string `json:",omitempty"`
+}
+
+// GetKinds returns the entries of the Kind registry, sorted by Kind, as JSON.
+func GetKinds() (string, error) {
+	registry := getRegistry()
+	registryInfo := KindRegistryInfo{
+		Kinds:         []KindRegistryEntry{},
+		RegistryError: GetRegistryError(),
+	}
+	for _, kind := range getResourceKinds() {
+		kindEntry := KindRegistryEntry{
+			Kind:         kind,
+			Plural:       getResourcePlural(kind),
+			APIVersion:   getAPIVersion(kind),
+			Endpoint:     registry.versionMap[kind],
+			Composition:  registry.compositionMap[kind],
+			MetadataOnly: registry.metadataOnlyMap[kind],
+			Layer:        registry.layerMap[kind],
+			Source:       registry.sourceMap[kind],
+			Overrides:    registry.overriddenMap[kind],
+		}
+		if statusRule, present := registry.statusRuleMap[kind]; present {
+			kindEntry.StatusRule = &statusRule
+		}
+		if openAPISpec := registry.openAPISpecMap[kind]; openAPISpec != (OpenAPISpecLocation{}) {
+			kindEntry.OpenAPISpec = &openAPISpec
+		}
+		registryInfo.Kinds = append(registryInfo.Kinds, kindEntry)
+	}
+
+	registryInfoBytes, err := json.Marshal(registryInfo)
+	if err != nil {
+		return "", err
+	}
+	return string(registryInfoBytes), nil
+}
@@ -0,0 +1,82 @@
+package discovery
+
+import (
+	"path"
+	"strings"
+	"time"
+)
+
+// Annotation added by 'kubectl apply' that holds a copy of the whole object
+const lastAppliedConfigAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// Used to select what is included in composition trees returned by GetCompositions.
+// Labels and Annotations are lists of globs matched against the keys; if not set all
+// labels, and all annotations except the last applied configuration, are included.
+// If Events is set the recent Events of each resource are included.
+type CompositionOptions struct {
+	Labels      []string
+	Annotations []string
+	Events      bool
+}
+
+// setMetaData copies the metadata of the resource to its node in the composition tree.
+func setMetaData(composition *Composition, metaData MetaDataAndOwnerReferences) {
+	composition.UID = metaData.UID
+	composition.APIVersion = metaData.APIVersion
+	composition.ResourceVersion = metaData.ResourceVersion
+	composition.CreationTimestamp = metaData.CreationTimestamp
+	composition.DeletionTimestamp = metaData.DeletionTimestamp
+	composition.Labels = metaData.Labels
+	composition.Annotations = metaData.Annotations
+	composition.requests = metaData.Requests
+	composition.limits = metaData.Limits
+	composition.containers = metaData.Containers
+	composition.Restarts = metaData.Diagnostics.Restarts
+	composition.LastTerminationReason = metaData.Diagnostics.LastTerminationReason
+	composition.WaitingReasons = metaData.Diagnostics.WaitingReasons
+	composition.Endpoints = metaData.Endpoints
+	if creationTime, err := time.Parse(time.RFC3339, metaData.CreationTimestamp); err == nil {
+		composition.Age = time.Since(creationTime).Round(time.Second).String()
+	}
+}
+
+// filterMetaData removes the labels and annotations not selected by the options
+// from the compositions and all their descendants.
+func filterMetaData(compositions []Composition, options CompositionOptions) {
+	for i := range compositions {
+		composition := &compositions[i]
+		composition.Labels = filterKeys(composition.Labels, options.Labels, nil)
+		composition.Annotations = filterKeys(composition.Annotations, options.Annotations,
+			[]string{lastAppliedConfigAnnotation})
+		filterMetaData(composition.Children, options)
+	}
+}
+
+// filterKeys returns the entries whose keys match one of the patterns. If there are
+// no patterns, all the entries except the excluded ones are returned.
+func filterKeys(values map[string]string, patterns []string, excluded []string) map[string]string {
+	filtered := make(map[string]string)
+	for key, value := range values {
+		if len(patterns) == 0 && contains(excluded, key) {
+			continue
+		}
+		if len(patterns) > 0 && !matchesKey(key, patterns) {
+			continue
+		}
+		filtered[key] = value
+	}
+	if len(filtered) == 0 {
+		return nil
+	}
+	return filtered
+}
+
+func matchesKey(key string, patterns []string) bool {
+	for _, pattern := range patterns {
+		// Keys are often prefixed (e.g. app.kubernetes.io/name), so '*' should match '/' as well
+		if matched, err := path.Match(strings.Replace(pattern, "/", "\x00", -1), strings.Replace(key, "/", "\x00", -1)); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
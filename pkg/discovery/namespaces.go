@@ -0,0 +1,101 @@
+package discovery
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// getNamespaces returns the namespaces whose composition trees should be built.
+// Namespaces are selected by the --include-namespaces/--exclude-namespaces globs and
+// the --include-namespace-selector/--exclude-namespace-selector label selectors.
+// If only plain namespace names are included, namespaces are not listed at all so that
+// the namespaced Kinds can be queried with namespaced Roles. Cluster-scoped resources
+// (CRDs, KindCompositions, PersistentVolumes and SubjectAccessReviews) still need a
+// ClusterRole.
+func getNamespaces() ([]string, error) {
+	includePatterns := splitNamespacePatterns(includeNamespaces)
+	excludePatterns := splitNamespacePatterns(excludeNamespaces)
+
+	excludeSelector := labels.Nothing()
+	if excludeNamespaceSelector != "" {
+		selector, err := labels.Parse(excludeNamespaceSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude namespace selector %s: %s", excludeNamespaceSelector, err.Error())
+		}
+		excludeSelector = selector
+	}
+	if includeNamespaceSelector != "" {
+		if _, err := labels.Parse(includeNamespaceSelector); err != nil {
+			return nil, fmt.Errorf("invalid include namespace selector %s: %s", includeNamespaceSelector, err.Error())
+		}
+	}
+
+	namespaces := make([]string, 0)
+	if len(includePatterns) > 0 && !hasGlob(includePatterns) &&
+		includeNamespaceSelector == "" && excludeNamespaceSelector == "" {
+		for _, namespace := range includePatterns {
+			if !matchesAny(namespace, excludePatterns) && !contains(namespaces, namespace) {
+				namespaces = append(namespaces, namespace)
+			}
+		}
+		return namespaces, nil
+	}
+
+	client, err := getKubeClient()
+	if err != nil {
+		return nil, err
+	}
+	namespaceList, err := client.CoreV1().Namespaces().List(metav1.ListOptions{
+		LabelSelector: includeNamespaceSelector,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, namespace := range namespaceList.Items {
+		name := namespace.Name
+		if len(includePatterns) > 0 && !matchesAny(name, includePatterns) {
+			continue
+		}
+		if matchesAny(name, excludePatterns) {
+			continue
+		}
+		if excludeSelector.Matches(labels.Set(namespace.Labels)) {
+			continue
+		}
+		namespaces = append(namespaces, name)
+	}
+	return namespaces, nil
+}
+
+func splitNamespacePatterns(patterns string) []string {
+	patternList := []string{}
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern != "" {
+			patternList = append(patternList, pattern)
+		}
+	}
+	return patternList
+}
+
+func hasGlob(patterns []string) bool {
+	for _, pattern := range patterns {
+		if strings.ContainsAny(pattern, "*?[") {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAny(namespace string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, err := path.Match(pattern, namespace); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
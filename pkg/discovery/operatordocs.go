@@ -5,91 +5,66 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"strings"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/coreos/etcd/client"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/clientcmd"
 )
 
-func getCRDNames(crdListString string) []string {
-	var operatorMapList []map[string]map[string]interface{}
-	var operatorDataMap map[string]interface{}
-
-	if err := json.Unmarshal([]byte(crdListString), &operatorMapList); err != nil {
-		fmt.Printf("Error:%s\n", err.Error())
+// GetOpenAPISpecLocation returns where the OpenAPI Spec of the given Kind is stored.
+// Fields that are not set in the Kind registry are defaulted to the layout used by
+// KubePlus: 'openapispec' key of a ConfigMap in the 'default' namespace with
+// definitions named 'typedir.<Kind>'. The ConfigMap name, if not set, is looked up
+// in etcd when the Spec is retrieved.
+func GetOpenAPISpecLocation(customResourceKind string) OpenAPISpecLocation {
+	location := getRegistry().openAPISpecMap[customResourceKind]
+	if location.Namespace == "" {
+		location.Namespace = "default"
 	}
-
-	var crdNameList []string = make([]string, 0)
-	for _, operator := range operatorMapList {
-		operatorDataMap = operator["Operator"]
-
-		customResources := operatorDataMap["CustomResources"]
-
-		for _, cr := range customResources.([]interface{}) {
-			crdNameList = append(crdNameList, cr.(string))
-		}
+	if location.Key == "" {
+		location.Key = "openapispec"
 	}
-	return crdNameList
-}
-
-func getCRDDetails(crdDetailsString string) (string, string, string, []string) {
-
-	var crdDetailsMap = make(map[string]interface{})
-	kind := ""
-	plural := ""
-	endpoint := ""
-	composition := make([]string, 0)
-
-	if err := json.Unmarshal([]byte(crdDetailsString), &crdDetailsMap); err != nil {
-		fmt.Printf("Error:%s\n", err.Error())
+	if location.DefinitionPrefix == "" {
+		location.DefinitionPrefix = "typedir."
 	}
-
-	kind = crdDetailsMap["kind"].(string)
-	endpoint = crdDetailsMap["endpoint"].(string)
-	plural = crdDetailsMap["plural"].(string)
-	compositionString := crdDetailsMap["composition"].(string)
-	composition1 := strings.Split(compositionString, ",")
-	for _, elem := range composition1 {
-		elem = strings.TrimSpace(elem)
-		composition = append(composition, elem)
-	}
-
-	return kind, plural, endpoint, composition
+	return location
 }
 
 func GetOpenAPISpec(customResourceKind string) string {
 
-	// 1. Get ConfigMap Name by querying etcd at
-	resourceKey := "/" + customResourceKind + "-OpenAPISpecConfigMap"
-	configMapNameString, err := queryETCD(resourceKey)
+	location := GetOpenAPISpecLocation(customResourceKind)
 
-	var configMapName string
-	if err := json.Unmarshal([]byte(configMapNameString), &configMapName); err != nil {
-		fmt.Printf("Error:%s\n", err.Error())
+	// 1. Get ConfigMap Name by querying etcd if it is not part of the Kind registry
+	configMapName := location.ConfigMap
+	if configMapName == "" {
+		resourceKey := "/" + customResourceKind + "-OpenAPISpecConfigMap"
+		configMapNameString, err := queryETCD(resourceKey)
+		if err != nil {
+			fmt.Printf("Error:%s\n", err.Error())
+			return ""
+		}
+		if err := json.Unmarshal([]byte(configMapNameString), &configMapName); err != nil {
+			fmt.Printf("Error:%s\n", err.Error())
+			return ""
+		}
 	}
 
 	// 2. Query ConfigMap
-	cfg, err := clientcmd.BuildConfigFromFlags(masterURL, kubeconfig)
-	if err != nil {
-		fmt.Printf("Error:%s\n", err.Error())
-	}
-
-	kubeClient, err := kubernetes.NewForConfig(cfg)
+	kubeClient, err := getKubeClient()
 	if err != nil {
 		fmt.Printf("Error:%s\n", err.Error())
+		return ""
 	}
 
-	configMap, err := kubeClient.CoreV1().ConfigMaps("default").Get(configMapName, metav1.GetOptions{})
+	configMap, err := kubeClient.CoreV1().ConfigMaps(location.Namespace).Get(configMapName, metav1.GetOptions{})
 
 	if err != nil {
 		fmt.Printf("Error:%s\n", err.Error())
+		return ""
 	}
 
 	configMapData := configMap.Data
-	openAPISpec := configMapData["openapispec"]
+	openAPISpec := configMapData[location.Key]
 
 	return openAPISpec
 }
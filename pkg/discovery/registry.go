@@ -0,0 +1,517 @@
+package discovery
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v2"
+)
+
+// Layers of the Kind registry in order of precedence: the entry of a Kind in a layer
+// replaces the entries of the same Kind in the layers before it.
+const (
+	LAYER_BUILT_IN         = "built-in"
+	LAYER_FILE             = "file"
+	LAYER_CRD_ANNOTATION   = "crd-annotation"
+	LAYER_KIND_COMPOSITION = "kindcomposition"
+	// Child Kinds that are not declared in any layer; they are resolved through the discovery API
+	LAYER_DISCOVERY = "discovery"
+)
+
+// Used to hold the Kind registry: the Kinds whose composition trees are built and
+// how to query them. A registry is never modified once it is in use; whenever one of
+// its layers changes a new registry is built and swapped in as a whole.
+type kindRegistry struct {
+	pluralMap      map[string]string
+	versionMap     map[string]string
+	compositionMap map[string][]string
+	openAPISpecMap map[string]OpenAPISpecLocation
+	// Kinds whose status is not needed and are listed as PartialObjectMetadataList
+	metadataOnlyMap map[string]bool
+	// Kinds whose status is extracted with the rules given in the Kind registry
+	statusRuleMap map[string]StatusRule
+	// Layer and source (file, CRD or KindComposition object) of the entry of each Kind
+	layerMap  map[string]string
+	sourceMap map[string]string
+	// Layers of the entries replaced by the entry of each Kind
+	overriddenMap map[string][]string
+}
+
+// Used to hold an entry of a layer of the Kind registry along with where it is defined
+type layerEntry struct {
+	entry  composition
+	source string
+}
+
+// Versions such as v1, v1beta2 or v2alpha1
+var apiVersionPattern = regexp.MustCompile(`^v[0-9]+((alpha|beta)[0-9]+)?$`)
+
+var (
+	currentKindRegistry *kindRegistry
+	// Registry built from the built-in, file and CRD annotation layers, on top of which
+	// the KindComposition objects are merged
+	baseKindRegistry *kindRegistry
+	// Last good entries of the file and CRD annotation layers
+	fileLayer          []layerEntry
+	crdAnnotationLayer []layerEntry
+	// Errors of the last attempt to load each layer, if it failed
+	layerErrors = make(map[string]string)
+	// Content of the Kind composition file from which the file layer was built
+	loadedKindCompositionFile []byte
+	kindRegistryMux           sync.RWMutex
+)
+
+func newKindRegistry() *kindRegistry {
+	return &kindRegistry{
+		pluralMap:       make(map[string]string),
+		versionMap:      make(map[string]string),
+		compositionMap:  make(map[string][]string),
+		openAPISpecMap:  make(map[string]OpenAPISpecLocation),
+		metadataOnlyMap: make(map[string]bool),
+		statusRuleMap:   make(map[string]StatusRule),
+		layerMap:        make(map[string]string),
+		sourceMap:       make(map[string]string),
+		overriddenMap:   make(map[string][]string),
+	}
+}
+
+// builtInKindRegistry returns a registry holding only the built-in Kinds.
+// Group/version of these Kinds is resolved through the discovery API.
+func builtInKindRegistry() *kindRegistry {
+	registry := newKindRegistry()
+	for _, compositionObj := range []composition{
+		{Kind: DEPLOYMENT, Plural: "deployments", Composition: []string{"ReplicaSet"}},
+		{Kind: REPLICA_SET, Plural: "replicasets", Composition: []string{"Pod"}},
+		{Kind: POD, Plural: "pods", Composition: []string{}},
+		{Kind: SERVICE, Plural: "services", Composition: []string{}, MetadataOnly: true},
+		{Kind: SECRET, Plural: "secrets", Composition: []string{}, MetadataOnly: true},
+		{Kind: PVCLAIM, Plural: "persistentvolumeclaims", Composition: []string{}},
+		{Kind: PV, Plural: "persistentvolumes", Composition: []string{}},
+	} {
+		registry.register(compositionObj, LAYER_BUILT_IN, "")
+	}
+	return registry
+}
+
+// register adds the Kind to the registry, replacing its previous entry if any.
+func (r *kindRegistry) register(compositionObj composition, layer, source string) error {
+	kind := compositionObj.Kind
+	if kind == "" {
+		return fmt.Errorf("kind is not set")
+	}
+	if compositionObj.Status.Path != "" {
+		if err := validateStatusRule(compositionObj.Status); err != nil {
+			return fmt.Errorf("status rule of %s: %s", kind, err.Error())
+		}
+	}
+	if compositionObj.Composition == nil {
+		compositionObj.Composition = []string{}
+	}
+	if previousLayer, present := r.layerMap[kind]; present {
+		overridden := append([]string{}, r.overriddenMap[kind]...)
+		r.overriddenMap[kind] = append(overridden, describeLayer(previousLayer, r.sourceMap[kind]))
+	}
+	r.pluralMap[kind] = compositionObj.Plural
+	r.versionMap[kind] = compositionObj.Endpoint
+	r.compositionMap[kind] = compositionObj.Composition
+	r.openAPISpecMap[kind] = compositionObj.OpenAPISpec
+	// Secret data is never read, whatever the layer the entry of Secret comes from
+	r.metadataOnlyMap[kind] = compositionObj.MetadataOnly || kind == SECRET
+	delete(r.statusRuleMap, kind)
+	if compositionObj.Status.Path != "" {
+		r.statusRuleMap[kind] = compositionObj.Status
+	}
+	r.layerMap[kind] = layer
+	r.sourceMap[kind] = source
+	return nil
+}
+
+// describeLayer returns the layer along with the source of the entry, e.g. 'file (/etc/kind_compositions.yaml)'.
+func describeLayer(layer, source string) string {
+	if source == "" {
+		return layer
+	}
+	return layer + " (" + source + ")"
+}
+
+// copy returns a registry with the same entries that can be modified independently.
+func (r *kindRegistry) copy() *kindRegistry {
+	registry := newKindRegistry()
+	for kind, composition := range r.compositionMap {
+		registry.compositionMap[kind] = composition
+		registry.pluralMap[kind] = r.pluralMap[kind]
+		registry.versionMap[kind] = r.versionMap[kind]
+		registry.openAPISpecMap[kind] = r.openAPISpecMap[kind]
+		registry.metadataOnlyMap[kind] = r.metadataOnlyMap[kind]
+		if statusRule, present := r.statusRuleMap[kind]; present {
+			registry.statusRuleMap[kind] = statusRule
+		}
+		registry.layerMap[kind] = r.layerMap[kind]
+		registry.sourceMap[kind] = r.sourceMap[kind]
+		if overridden, present := r.overriddenMap[kind]; present {
+			registry.overriddenMap[kind] = overridden
+		}
+	}
+	return registry
+}
+
+// getRegistry returns the current Kind registry. Callers must not modify it.
+func getRegistry() *kindRegistry {
+	kindRegistryMux.RLock()
+	defer kindRegistryMux.RUnlock()
+	return currentKindRegistry
+}
+
+// buildBaseRegistry builds a registry from the built-in Kinds followed by the entries of
+// the file and CRD annotation layers. It returns the problems of the hierarchy as a
+// whole, such as cycles between Kinds of different layers.
+func buildBaseRegistry(fileEntries, crdAnnotationEntries []layerEntry) (*kindRegistry, []string) {
+	registry := builtInKindRegistry()
+	problems := []string{}
+	for _, layer := range []struct {
+		name    string
+		entries []layerEntry
+	}{
+		{LAYER_FILE, fileEntries},
+		{LAYER_CRD_ANNOTATION, crdAnnotationEntries},
+	} {
+		for _, entry := range layer.entries {
+			if err := registry.register(entry.entry, layer.name, entry.source); err != nil {
+				problems = append(problems, err.Error())
+			}
+		}
+	}
+	registry.declareChildKinds()
+	problems = append(problems, registry.validate()...)
+	return registry, problems
+}
+
+// filterCRDAnnotationEntries returns the CRD annotation entries that can be added one at a
+// time on top of the built-in and file layers, along with the problems of the others
+// (e.g. a status rule that cannot be parsed or a cycle with Kinds of other CRDs).
+func filterCRDAnnotationEntries(entries []layerEntry) ([]layerEntry, []string) {
+	kindRegistryMux.RLock()
+	fileEntries := fileLayer
+	kindRegistryMux.RUnlock()
+	accepted := []layerEntry{}
+	problems := []string{}
+	for _, entry := range entries {
+		candidate := append(append([]layerEntry{}, accepted...), entry)
+		if _, entryProblems := buildBaseRegistry(fileEntries, candidate); len(entryProblems) > 0 {
+			problems = append(problems, fmt.Sprintf("CRD %s: %s", entry.source, strings.Join(entryProblems, "; ")))
+			continue
+		}
+		accepted = append(accepted, entry)
+	}
+	return accepted, problems
+}
+
+// setLayer replaces the entries of the file or CRD annotation layer and rebuilds the
+// Kind registry, into which the KindComposition objects are then merged. The entries
+// are rejected, and the last good ones kept, if the resulting registry is not valid.
+func setLayer(layer string, entries []layerEntry) error {
+	kindRegistryMux.Lock()
+	fileEntries, crdAnnotationEntries := fileLayer, crdAnnotationLayer
+	if layer == LAYER_FILE {
+		fileEntries = entries
+	} else {
+		crdAnnotationEntries = entries
+	}
+	registry, problems := buildBaseRegistry(fileEntries, crdAnnotationEntries)
+	if len(problems) > 0 {
+		err := fmt.Errorf("invalid Kind registry, keeping the last good entries of the %s layer: %s",
+			layer, strings.Join(problems, "; "))
+		layerErrors[layer] = err.Error()
+		kindRegistryMux.Unlock()
+		return err
+	}
+	fileLayer, crdAnnotationLayer = fileEntries, crdAnnotationEntries
+	delete(layerErrors, layer)
+	baseKindRegistry = registry
+	var statuses map[string]kindCompositionStatus
+	currentKindRegistry, statuses = mergeKindCompositions(registry, kindCompositionObjects)
+	objects := kindCompositionObjects
+	kindRegistryMux.Unlock()
+	if len(objects) > 0 {
+		updateKindCompositionStatuses(objects, statuses)
+	}
+	return nil
+}
+
+// setKindCompositions replaces the KindComposition objects merged into the base registry.
+func setKindCompositions(objects []kindCompositionObject) {
+	kindRegistryMux.Lock()
+	kindCompositionObjects = objects
+	var statuses map[string]kindCompositionStatus
+	currentKindRegistry, statuses = mergeKindCompositions(baseKindRegistry, objects)
+	kindRegistryMux.Unlock()
+	if len(objects) > 0 {
+		updateKindCompositionStatuses(objects, statuses)
+	}
+}
+
+func setLayerError(layer string, err error) {
+	kindRegistryMux.Lock()
+	defer kindRegistryMux.Unlock()
+	layerErrors[layer] = err.Error()
+}
+
+// GetRegistryError returns why layers of the Kind registry could not be (re)loaded, if
+// it failed. The last good entries of these layers remain in use in that case.
+func GetRegistryError() string {
+	kindRegistryMux.RLock()
+	defer kindRegistryMux.RUnlock()
+	registryErrors := []string{}
+	for _, layer := range []string{LAYER_FILE, LAYER_CRD_ANNOTATION} {
+		if layerError, present := layerErrors[layer]; present {
+			registryErrors = append(registryErrors, layerError)
+		}
+	}
+	return strings.Join(registryErrors, "; ")
+}
+
+// GetKindPlurals returns the plurals of the Kinds in the Kind registry. Kinds whose
+// plural is resolved through the discovery API are not included.
+func GetKindPlurals() map[string]string {
+	plurals := make(map[string]string)
+	for kind, plural := range getRegistry().pluralMap {
+		if plural != "" {
+			plurals[kind] = plural
+		}
+	}
+	return plurals
+}
+
+// loadKindCompositionFile rebuilds the file layer of the Kind registry if the content of
+// the file changed. A file that cannot be read or is invalid is rejected as a whole and
+// the last good entries of the layer are kept.
+func loadKindCompositionFile(filePath string) error {
+	content, err := ioutil.ReadFile(filePath)
+	if err == nil {
+		kindRegistryMux.Lock()
+		unchanged := bytes.Equal(content, loadedKindCompositionFile)
+		if unchanged {
+			// A previous error (e.g. a file that was being replaced) no longer applies
+			delete(layerErrors, LAYER_FILE)
+		}
+		kindRegistryMux.Unlock()
+		if unchanged {
+			return nil
+		}
+		var compositionsList []composition
+		compositionsList, err = parseKindCompositionFile(content)
+		if err == nil {
+			entries := []layerEntry{}
+			for _, compositionObj := range compositionsList {
+				entries = append(entries, layerEntry{compositionObj, filePath})
+			}
+			if err = setLayer(LAYER_FILE, entries); err != nil {
+				return err
+			}
+			kindRegistryMux.Lock()
+			loadedKindCompositionFile = content
+			kindRegistryMux.Unlock()
+			fmt.Printf("Loaded %d Kinds from %s\n", len(entries), filePath)
+			return nil
+		}
+	}
+	err = fmt.Errorf("could not load %s, keeping the last good entries of the file layer: %s", filePath, err.Error())
+	setLayerError(LAYER_FILE, err)
+	return err
+}
+
+// parseKindCompositionFile returns the entries of the file.
+// The file is rejected if any of the checks of lintKindCompositionFile fails.
+func parseKindCompositionFile(content []byte) ([]composition, error) {
+	compositionsList, problems := lintKindCompositionFile(content)
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return compositionsList, nil
+}
+
+// lintKindCompositionFile returns the entries in the file along with all the problems
+// found in the file. The entries are checked on top of the built-in Kinds. Unlike in the
+// CRD annotation and KindComposition layers, child Kinds must be declared in the file
+// or be built-in so that typos in Kind names do not silently yield empty trees.
+func lintKindCompositionFile(content []byte) ([]composition, []string) {
+	compositionsList := make([]composition, 0)
+	if err := yaml.UnmarshalStrict(content, &compositionsList); err != nil {
+		return nil, []string{err.Error()}
+	}
+	problems := []string{}
+	registry := builtInKindRegistry()
+	declared := make(map[string]int)
+	for i, compositionObj := range compositionsList {
+		if entry, present := declared[compositionObj.Kind]; present && compositionObj.Kind != "" {
+			problems = append(problems, fmt.Sprintf("entry %d: kind %s is already declared in entry %d",
+				i+1, compositionObj.Kind, entry))
+			continue
+		}
+		declared[compositionObj.Kind] = i + 1
+		// Entries with problems are still registered so that the hierarchy can be checked as a whole
+		entryProblems := validateComposition(compositionObj)
+		if compositionObj.Kind != "" {
+			if err := registry.register(compositionObj, LAYER_FILE, ""); err != nil {
+				entryProblems = append(entryProblems, err.Error())
+			}
+		}
+		for _, problem := range entryProblems {
+			problems = append(problems, fmt.Sprintf("entry %d: %s", i+1, problem))
+		}
+	}
+	problems = append(problems, registry.validate()...)
+	return compositionsList, problems
+}
+
+// ValidateKindCompositionFile returns the problems found in the Kind composition file,
+// or none if it would be accepted by the Kind registry.
+func ValidateKindCompositionFile(filePath string) []string {
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	_, problems := lintKindCompositionFile(content)
+	return problems
+}
+
+// validateComposition checks a single entry of the Kind registry.
+func validateComposition(compositionObj composition) []string {
+	problems := []string{}
+	if compositionObj.Kind == "" {
+		return append(problems, "kind is not set")
+	}
+	if compositionObj.Plural == "" {
+		problems = append(problems, fmt.Sprintf("plural of %s is not set", compositionObj.Kind))
+	}
+	if compositionObj.Endpoint != "" && !validEndpoint(compositionObj.Endpoint) {
+		problems = append(problems, fmt.Sprintf("endpoint %s of %s is not of the form api/<version> or apis/<group>/<version>",
+			compositionObj.Endpoint, compositionObj.Kind))
+	}
+	return problems
+}
+
+// validEndpoint checks that the endpoint starts with api/<version> or apis/<group>/<version>.
+func validEndpoint(endpoint string) bool {
+	parts := strings.Split(strings.Trim(endpoint, "/"), "/")
+	switch {
+	case len(parts) >= 2 && parts[0] == "api":
+		return apiVersionPattern.MatchString(parts[1])
+	case len(parts) >= 3 && parts[0] == "apis":
+		return parts[1] != "" && apiVersionPattern.MatchString(parts[2])
+	}
+	return false
+}
+
+// validate checks that every child Kind is declared in the registry and that the
+// composition hierarchy has no cycles.
+func (r *kindRegistry) validate() []string {
+	problems := []string{}
+	kinds := make([]string, 0)
+	for kind := range r.compositionMap {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		for _, childKind := range r.compositionMap[kind] {
+			if _, declared := r.compositionMap[childKind]; !declared {
+				problems = append(problems, fmt.Sprintf("child kind %s of %s is not declared", childKind, kind))
+			}
+		}
+	}
+
+	// Depth first search; a child that is on the current path closes a cycle
+	const (
+		unvisited = iota
+		onPath
+		done
+	)
+	state := make(map[string]int)
+	path := []string{}
+	var visit func(kind string)
+	visit = func(kind string) {
+		state[kind] = onPath
+		path = append(path, kind)
+		for _, childKind := range r.compositionMap[kind] {
+			if _, declared := r.compositionMap[childKind]; !declared {
+				continue
+			}
+			switch state[childKind] {
+			case unvisited:
+				visit(childKind)
+			case onPath:
+				for i := range path {
+					if path[i] == childKind {
+						cycle := append(append([]string{}, path[i:]...), childKind)
+						problems = append(problems, fmt.Sprintf("composition of %s has a cycle: %s",
+							childKind, strings.Join(cycle, " -> ")))
+						break
+					}
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[kind] = done
+	}
+	for _, kind := range kinds {
+		if state[kind] == unvisited {
+			visit(kind)
+		}
+	}
+	return problems
+}
+
+// watchKindCompositionFile reloads the Kind registry whenever the file changes.
+// The directory of the file is watched rather than the file itself so that files
+// replaced by editors, and ConfigMap volumes (where the file is a symlink into a
+// '..data' directory that is swapped on every update), are handled as well.
+func watchKindCompositionFile(filePath string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(filePath)); err != nil {
+		watcher.Close()
+		return err
+	}
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op&fsnotify.Chmod == event.Op {
+					continue
+				}
+				if err := loadKindCompositionFile(filePath); err != nil {
+					fmt.Printf("Error: %s\n", err.Error())
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				fmt.Printf("Error: watching %s: %s\n", filePath, err.Error())
+			}
+		}
+	}()
+	return nil
+}
+
+// getResourceKinds returns the Kinds in the Kind registry.
+func getResourceKinds() []string {
+	resourceKindSlice := make([]string, 0)
+	for key := range getRegistry().compositionMap {
+		resourceKindSlice = append(resourceKindSlice, key)
+	}
+	sort.Strings(resourceKindSlice)
+	return resourceKindSlice
+}
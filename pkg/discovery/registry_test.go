@@ -0,0 +1,327 @@
+package discovery
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLintKindCompositionFile(t *testing.T) {
+	testCases := []struct {
+		name     string
+		content  string
+		expected []string
+	}{
+		{
+			name: "valid",
+			content: `
+- kind: MysqlCluster
+  plural: mysqlclusters
+  endpoint: apis/mysql.example.com/v1beta1
+  composition: [StatefulSet, Service]
+- kind: StatefulSet
+  plural: statefulsets
+  endpoint: apis/apps/v1
+  composition: [Pod]
+`,
+			expected: []string{},
+		},
+		{
+			name: "duplicate kind",
+			content: `
+- kind: MysqlCluster
+  plural: mysqlclusters
+- kind: Backup
+  plural: backups
+- kind: MysqlCluster
+  plural: mysqlclusters
+`,
+			expected: []string{"entry 3: kind MysqlCluster is already declared in entry 1"},
+		},
+		{
+			name: "kind and plural not set",
+			content: `
+- plural: mysqlclusters
+- kind: MysqlCluster
+`,
+			expected: []string{"entry 1: kind is not set", "entry 2: plural of MysqlCluster is not set"},
+		},
+		{
+			name: "cycle",
+			content: `
+- kind: MysqlCluster
+  plural: mysqlclusters
+  composition: [Backup]
+- kind: Backup
+  plural: backups
+  composition: [MysqlCluster]
+`,
+			expected: []string{"composition of Backup has a cycle: Backup -> MysqlCluster -> Backup"},
+		},
+		{
+			name: "self cycle",
+			content: `
+- kind: MysqlCluster
+  plural: mysqlclusters
+  composition: [MysqlCluster]
+`,
+			expected: []string{"composition of MysqlCluster has a cycle: MysqlCluster -> MysqlCluster"},
+		},
+		{
+			name: "cycle through a built-in Kind",
+			content: `
+- kind: Pod
+  plural: pods
+  composition: [Deployment]
+`,
+			expected: []string{"composition of Deployment has a cycle: Deployment -> ReplicaSet -> Pod -> Deployment"},
+		},
+		{
+			name: "undeclared child kind",
+			content: `
+- kind: MysqlCluster
+  plural: mysqlclusters
+  composition: [StatefulSet]
+`,
+			expected: []string{"child kind StatefulSet of MysqlCluster is not declared"},
+		},
+		{
+			name: "misspelled built-in child kind",
+			content: `
+- kind: MysqlCluster
+  plural: mysqlclusters
+  composition: [Replicaset]
+`,
+			expected: []string{"child kind Replicaset of MysqlCluster is not declared"},
+		},
+		{
+			name: "invalid status rule",
+			content: `
+- kind: MysqlCluster
+  plural: mysqlclusters
+  status:
+    path: "{.status.phase"
+`,
+			expected: []string{"entry 1: status rule of MysqlCluster: invalid JSONPath {.status.phase: unclosed action"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		_, problems := lintKindCompositionFile([]byte(testCase.content))
+		if !reflect.DeepEqual(problems, testCase.expected) {
+			t.Errorf("%s: expected problems %q, got %q", testCase.name, testCase.expected, problems)
+		}
+	}
+}
+
+func TestLintKindCompositionFileUnknownFields(t *testing.T) {
+	testCases := []struct {
+		name    string
+		content string
+		field   string
+	}{
+		{
+			name: "misspelled field",
+			content: `
+- kind: MysqlCluster
+  plurals: mysqlclusters
+`,
+			field: "plurals",
+		},
+		{
+			name: "misspelled nested field",
+			content: `
+- kind: MysqlCluster
+  plural: mysqlclusters
+  openapispec:
+    configMap: mysql-openapispec
+`,
+			field: "configMap",
+		},
+	}
+
+	for _, testCase := range testCases {
+		compositionsList, problems := lintKindCompositionFile([]byte(testCase.content))
+		if compositionsList != nil || len(problems) != 1 || !strings.Contains(problems[0], testCase.field) {
+			t.Errorf("%s: expected field %s to be reported, got %q", testCase.name, testCase.field, problems)
+		}
+	}
+}
+
+func TestValidEndpoint(t *testing.T) {
+	testCases := []struct {
+		endpoint string
+		expected bool
+	}{
+		{"api/v1", true},
+		{"/api/v1/", true},
+		{"apis/apps/v1", true},
+		{"apis/mysql.example.com/v1beta1", true},
+		{"apis/batch/v2alpha1", true},
+		{"apis/apps/v1/namespaces", true},
+		{"api", false},
+		{"apis/apps", false},
+		{"apis//v1", false},
+		{"apis/apps/1", false},
+		{"apis/apps/v1gamma1", false},
+		{"apis/apps/v1beta", false},
+		{"api/apps/v1", false},
+		{"apps/v1", false},
+	}
+
+	for _, testCase := range testCases {
+		if valid := validEndpoint(testCase.endpoint); valid != testCase.expected {
+			t.Errorf("endpoint %s: expected %t, got %t", testCase.endpoint, testCase.expected, valid)
+		}
+	}
+}
+
+func TestValidateComposition(t *testing.T) {
+	testCases := []struct {
+		name     string
+		entry    composition
+		expected []string
+	}{
+		{"valid", composition{Kind: "MysqlCluster", Plural: "mysqlclusters", Endpoint: "apis/mysql.example.com/v1"}, []string{}},
+		{"endpoint resolved through discovery", composition{Kind: "MysqlCluster", Plural: "mysqlclusters"}, []string{}},
+		{"kind not set", composition{Plural: "mysqlclusters"}, []string{"kind is not set"}},
+		{"invalid endpoint", composition{Kind: "MysqlCluster", Plural: "mysqlclusters", Endpoint: "mysql.example.com/v1"},
+			[]string{"endpoint mysql.example.com/v1 of MysqlCluster is not of the form api/<version> or apis/<group>/<version>"}},
+	}
+
+	for _, testCase := range testCases {
+		if problems := validateComposition(testCase.entry); !reflect.DeepEqual(problems, testCase.expected) {
+			t.Errorf("%s: expected problems %q, got %q", testCase.name, testCase.expected, problems)
+		}
+	}
+}
+
+func TestBuildBaseRegistry(t *testing.T) {
+	testCases := []struct {
+		name       string
+		file       []layerEntry
+		crd        []layerEntry
+		expected   []string
+		layer      string
+		overridden []string
+	}{
+		{
+			name:     "built-in Kinds only",
+			expected: []string{},
+			layer:    LAYER_BUILT_IN,
+		},
+		{
+			name:       "file entry replaces built-in entry",
+			file:       []layerEntry{{composition{Kind: "Pod", Plural: "pods"}, "kind_compositions.yaml"}},
+			expected:   []string{},
+			layer:      LAYER_FILE,
+			overridden: []string{LAYER_BUILT_IN},
+		},
+		{
+			name: "CRD annotation replaces file entry",
+			file: []layerEntry{{composition{Kind: "Pod", Plural: "pods"}, "kind_compositions.yaml"}},
+			crd: []layerEntry{{composition{Kind: "Pod", Plural: "pods", Endpoint: "api/v1"},
+				"pods.example.com"}},
+			expected:   []string{},
+			layer:      LAYER_CRD_ANNOTATION,
+			overridden: []string{LAYER_BUILT_IN, LAYER_FILE + " (kind_compositions.yaml)"},
+		},
+		{
+			name: "cycle across layers",
+			file: []layerEntry{{composition{Kind: "Backup", Plural: "backups",
+				Composition: []string{"MysqlCluster"}}, "kind_compositions.yaml"}},
+			crd: []layerEntry{{composition{Kind: "MysqlCluster", Plural: "mysqlclusters",
+				Composition: []string{"Backup"}}, "mysqlclusters.mysql.example.com"}},
+			expected: []string{"composition of Backup has a cycle: Backup -> MysqlCluster -> Backup"},
+			layer:    LAYER_BUILT_IN,
+		},
+	}
+
+	for _, testCase := range testCases {
+		registry, problems := buildBaseRegistry(testCase.file, testCase.crd)
+		if !reflect.DeepEqual(problems, testCase.expected) {
+			t.Errorf("%s: expected problems %q, got %q", testCase.name, testCase.expected, problems)
+		}
+		if layer := registry.layerMap[POD]; layer != testCase.layer {
+			t.Errorf("%s: expected Pod from the %s layer, got %s", testCase.name, testCase.layer, layer)
+		}
+		if overridden := registry.overriddenMap[POD]; !reflect.DeepEqual(overridden, testCase.overridden) {
+			t.Errorf("%s: expected Pod to override %q, got %q", testCase.name, testCase.overridden, overridden)
+		}
+	}
+}
+
+func TestFilterCRDAnnotationEntries(t *testing.T) {
+	mysqlCluster := layerEntry{composition{Kind: "MysqlCluster", Plural: "mysqlclusters",
+		Composition: []string{"Backup"}}, "mysqlclusters.mysql.example.com"}
+	backup := layerEntry{composition{Kind: "Backup", Plural: "backups",
+		Composition: []string{"MysqlCluster"}}, "backups.mysql.example.com"}
+	postgres := layerEntry{composition{Kind: "Postgres", Plural: "postgreses",
+		Composition: []string{"Deployment"}}, "postgreses.postgrescontroller.kubeplus"}
+	badStatusRule := layerEntry{composition{Kind: "Etcd", Plural: "etcds",
+		Status: StatusRule{Path: "{.status"}}, "etcds.example.com"}
+
+	testCases := []struct {
+		name     string
+		entries  []layerEntry
+		accepted []string
+		expected []string
+	}{
+		{
+			name:     "all valid",
+			entries:  []layerEntry{mysqlCluster, postgres},
+			accepted: []string{"MysqlCluster", "Postgres"},
+			expected: []string{},
+		},
+		{
+			name:     "CRD closing a cycle is skipped",
+			entries:  []layerEntry{mysqlCluster, backup, postgres},
+			accepted: []string{"MysqlCluster", "Postgres"},
+			expected: []string{"CRD backups.mysql.example.com: composition of Backup has a cycle: Backup -> MysqlCluster -> Backup"},
+		},
+		{
+			name:     "CRD with an invalid status rule is skipped",
+			entries:  []layerEntry{badStatusRule, postgres},
+			accepted: []string{"Postgres"},
+			expected: []string{"CRD etcds.example.com: status rule of Etcd: invalid JSONPath {.status: unclosed action"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		accepted, problems := filterCRDAnnotationEntries(testCase.entries)
+		acceptedKinds := []string{}
+		for _, entry := range accepted {
+			acceptedKinds = append(acceptedKinds, entry.entry.Kind)
+		}
+		if !reflect.DeepEqual(acceptedKinds, testCase.accepted) {
+			t.Errorf("%s: expected %q to be accepted, got %q", testCase.name, testCase.accepted, acceptedKinds)
+		}
+		if !reflect.DeepEqual(problems, testCase.expected) {
+			t.Errorf("%s: expected problems %q, got %q", testCase.name, testCase.expected, problems)
+		}
+	}
+}
+
+func TestRegisterMetadataOnly(t *testing.T) {
+	testCases := []struct {
+		name     string
+		entry    composition
+		expected bool
+	}{
+		{"Secret is always metadata-only", composition{Kind: "Secret", Plural: "secrets"}, true},
+		{"Service entry without metadataOnly", composition{Kind: "Service", Plural: "services"}, false},
+		{"Service entry with metadataOnly", composition{Kind: "Service", Plural: "services", MetadataOnly: true}, true},
+	}
+
+	for _, testCase := range testCases {
+		for _, layer := range []string{LAYER_FILE, LAYER_CRD_ANNOTATION, LAYER_KIND_COMPOSITION} {
+			registry := builtInKindRegistry()
+			if err := registry.register(testCase.entry, layer, ""); err != nil {
+				t.Fatalf("%s: %s", testCase.name, err.Error())
+			}
+			if metadataOnly := registry.metadataOnlyMap[testCase.entry.Kind]; metadataOnly != testCase.expected {
+				t.Errorf("%s in the %s layer: expected metadataOnly %t, got %t", testCase.name, layer, testCase.expected, metadataOnly)
+			}
+		}
+	}
+}
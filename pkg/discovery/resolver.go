@@ -0,0 +1,253 @@
+package discovery
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	k8sdiscovery "k8s.io/client-go/discovery"
+)
+
+// Used to hold the group, version and resource through which a Kind is served
+type resolvedResource struct {
+	Group      string
+	Version    string
+	Resource   string
+	Namespaced bool
+}
+
+// Used to resolve Kinds to their preferred served group/version/resource using
+// the discovery API of the main API server.
+// Resolution is redone whenever the set of preferred group versions served by the
+// API server changes (e.g. when a CRD is upgraded to a new version), or when it is
+// explicitly invalidated (e.g. when a query returns 404).
+type resourceResolver struct {
+	resources     map[string][]resolvedResource
+	groupVersions string
+	stale         bool
+	// Time at which the resolution was last invalidated because a Kind was not served
+	lastUnresolved time.Time
+	mux            sync.Mutex
+}
+
+// Minimum time between two resolutions caused by Kinds that are not served
+const unresolvedInterval = time.Minute
+
+var (
+	kindResolver = &resourceResolver{stale: true}
+)
+
+func (r *resourceResolver) invalidate() {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.stale = true
+}
+
+// invalidateUnresolved marks the resolution as stale because a Kind is not served.
+// The Kind may be served in a group/version whose resources were already resolved, e.g.
+// a second CRD of an operator, which does not change the preferred group versions.
+// It is rate limited so that Kinds that are really not served do not cause a full
+// resolution in every cycle.
+func (r *resourceResolver) invalidateUnresolved() {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if time.Since(r.lastUnresolved) < unresolvedInterval {
+		return
+	}
+	r.lastUnresolved = time.Now()
+	r.stale = true
+}
+
+// refresh re-resolves all the served Kinds if the resolution is stale.
+// It is called once at the beginning of every build cycle.
+func (r *resourceResolver) refresh() error {
+	client, err := getKubeClient()
+	if err != nil {
+		return err
+	}
+	groupList, err := client.Discovery().ServerGroups()
+	if err != nil {
+		return err
+	}
+	groupVersions := []string{}
+	for _, group := range groupList.Groups {
+		groupVersions = append(groupVersions, group.PreferredVersion.GroupVersion)
+	}
+	sort.Strings(groupVersions)
+	fingerprint := strings.Join(groupVersions, ",")
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if !r.stale && fingerprint == r.groupVersions {
+		return nil
+	}
+
+	resourceLists, err := client.Discovery().ServerPreferredResources()
+	if err != nil && !k8sdiscovery.IsGroupDiscoveryFailedError(err) {
+		return err
+	}
+	if err != nil {
+		// Some of the aggregated APIs may be unavailable; resolve whatever is served
+		fmt.Printf("Error: %s\n", err.Error())
+	}
+
+	resources := make(map[string][]resolvedResource)
+	for _, resourceList := range resourceLists {
+		if resourceList == nil {
+			continue
+		}
+		group, version := splitGroupVersion(resourceList.GroupVersion)
+		for _, apiResource := range resourceList.APIResources {
+			// Skip subresources such as deployments/scale
+			if strings.Contains(apiResource.Name, "/") {
+				continue
+			}
+			resources[apiResource.Kind] = append(resources[apiResource.Kind], resolvedResource{
+				Group:      group,
+				Version:    version,
+				Resource:   apiResource.Name,
+				Namespaced: apiResource.Namespaced,
+			})
+		}
+	}
+	r.resources = resources
+	r.groupVersions = fingerprint
+	// Only remain stale if discovery was incomplete so that we retry in the next cycle
+	r.stale = err != nil
+	fmt.Printf("Resolved %d Kinds served by the API server\n", len(resources))
+	return nil
+}
+
+// resolve returns the preferred served resource of the Kind. If the plural of the
+// Kind is known it is used to choose between Kinds of the same name in different groups.
+// Otherwise the core group is preferred, followed by any group other than the
+// deprecated 'extensions' group.
+func (r *resourceResolver) resolve(resourceKind, plural string) (resolvedResource, bool) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	var resolved resolvedResource
+	found := false
+	for _, candidate := range r.resources[resourceKind] {
+		if plural != "" && candidate.Resource != plural {
+			continue
+		}
+		if !found || resourcePriority(candidate) < resourcePriority(resolved) {
+			resolved = candidate
+			found = true
+		}
+	}
+	return resolved, found
+}
+
+func resourcePriority(resource resolvedResource) int {
+	switch resource.Group {
+	case "":
+		return 0
+	case "extensions":
+		return 2
+	}
+	return 1
+}
+
+func splitGroupVersion(groupVersion string) (string, string) {
+	parts := strings.SplitN(groupVersion, "/", 2)
+	if len(parts) == 1 {
+		return "", parts[0]
+	}
+	return parts[0], parts[1]
+}
+
+// getResourcePath returns the path for listing resources of the Kind in the namespace.
+// The 'endpoint' of a Kind in the Kind registry, if set, overrides the
+// group/version resolved through the discovery API.
+func getResourcePath(resourceKind, namespace string) (string, error) {
+	resourcePlural := getRegistry().pluralMap[resourceKind]
+	resourceApiVersion := getRegistry().versionMap[resourceKind]
+	if resourceApiVersion != "" {
+		if resourcePlural != "" && !strings.Contains(resourceApiVersion, resourcePlural) {
+			return fmt.Sprintf("/%s/namespaces/%s/%s", resourceApiVersion, namespace, resourcePlural), nil
+		}
+		return fmt.Sprintf("/%s", resourceApiVersion), nil
+	}
+
+	resolved, found := kindResolver.resolve(resourceKind, resourcePlural)
+	if !found {
+		// Resolve again in the next cycle in case the Kind was added since
+		kindResolver.invalidateUnresolved()
+		return "", fmt.Errorf("Kind %s is not served by the API server", resourceKind)
+	}
+	prefix := "apis/" + resolved.Group + "/" + resolved.Version
+	if resolved.Group == "" {
+		prefix = "api/" + resolved.Version
+	}
+	if !resolved.Namespaced {
+		return fmt.Sprintf("/%s/%s", prefix, resolved.Resource), nil
+	}
+	return fmt.Sprintf("/%s/namespaces/%s/%s", prefix, namespace, resolved.Resource), nil
+}
+
+// getClusterResourcePath returns the path for listing the cluster-scoped resources of
+// the Kind at the group/version preferred by the API server, e.g. apiextensions.k8s.io/v1
+// or v1beta1 for CustomResourceDefinitions depending on the version of the API server.
+// The Kinds are resolved again, at most once a minute, if the Kind is not served as it
+// may just have been added.
+func getClusterResourcePath(resourceKind, resourcePlural string) (string, bool, error) {
+	resolved, found := kindResolver.resolve(resourceKind, resourcePlural)
+	if !found {
+		kindResolver.invalidateUnresolved()
+		if err := kindResolver.refresh(); err != nil {
+			return "", false, err
+		}
+		if resolved, found = kindResolver.resolve(resourceKind, resourcePlural); !found {
+			return "", false, nil
+		}
+	}
+	return fmt.Sprintf("/apis/%s/%s/%s", resolved.Group, resolved.Version, resolved.Resource), true, nil
+}
+
+// getResourcePlural returns the plural of the Kind from the Kind registry,
+// falling back to the resource name served by the API server.
+func getResourcePlural(resourceKind string) string {
+	resourcePlural := getRegistry().pluralMap[resourceKind]
+	if resourcePlural == "" {
+		if resolved, found := kindResolver.resolve(resourceKind, ""); found {
+			resourcePlural = resolved.Resource
+		}
+	}
+	return resourcePlural
+}
+
+// getGroupVersionResource returns the group, version and resource (plural) of the Kind.
+// The 'endpoint' of a Kind in the Kind registry, if set, overrides the group/version
+// resolved through the discovery API. It returns false if the group/version of the Kind
+// cannot be determined.
+func getGroupVersionResource(resourceKind string) (string, string, string, bool) {
+	if resourceApiVersion := getRegistry().versionMap[resourceKind]; resourceApiVersion != "" {
+		resourcePlural := getResourcePlural(resourceKind)
+		// Endpoints are of the form api/v1 or apis/<group>/<version>
+		parts := strings.Split(strings.Trim(resourceApiVersion, "/"), "/")
+		if len(parts) >= 3 && parts[0] == "apis" {
+			return parts[1], parts[2], resourcePlural, true
+		}
+		if len(parts) >= 2 && parts[0] == "api" {
+			return "", parts[1], resourcePlural, true
+		}
+		return "", "", resourcePlural, false
+	}
+	resolved, found := kindResolver.resolve(resourceKind, getRegistry().pluralMap[resourceKind])
+	return resolved.Group, resolved.Version, resolved.Resource, found
+}
+
+// getAPIVersion returns the apiVersion (group/version) of resources of the Kind.
+func getAPIVersion(resourceKind string) string {
+	group, version, _, found := getGroupVersionResource(resourceKind)
+	if !found {
+		return ""
+	}
+	if group == "" {
+		return version
+	}
+	return group + "/" + version
+}
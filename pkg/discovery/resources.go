@@ -0,0 +1,123 @@
+package discovery
+
+import (
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// parseResources returns the compute resources reserved by the object: the effective
+// requests and limits of a Pod, or the storage requested by a PersistentVolumeClaim.
+// Other Kinds do not reserve resources of their own.
+func parseResources(resourceKind string, object map[string]interface{}) (map[string]resource.Quantity, map[string]resource.Quantity) {
+	switch resourceKind {
+	case POD:
+		return parsePodResources(object)
+	case PVCLAIM:
+		return parseResourceList(nestedField(object, "spec", "resources", "requests")), nil
+	}
+	return nil, nil
+}
+
+// parsePodResources computes the effective requests and limits of the Pod in the same
+// way as the scheduler: the sum over the containers or the largest init container,
+// whichever is higher, plus the Pod overhead. Pods that have terminated do not
+// reserve any resources.
+func parsePodResources(object map[string]interface{}) (map[string]resource.Quantity, map[string]resource.Quantity) {
+	phase := nestedString(object, "status", "phase")
+	if phase == "Succeeded" || phase == "Failed" {
+		return nil, nil
+	}
+	requests := make(map[string]resource.Quantity)
+	limits := make(map[string]resource.Quantity)
+	for _, container := range nestedSlice(object, "spec", "containers") {
+		containerMap, ok := container.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		addResources(requests, parseResourceList(nestedField(containerMap, "resources", "requests")))
+		addResources(limits, parseResourceList(nestedField(containerMap, "resources", "limits")))
+	}
+	for _, container := range nestedSlice(object, "spec", "initContainers") {
+		containerMap, ok := container.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		maxResources(requests, parseResourceList(nestedField(containerMap, "resources", "requests")))
+		maxResources(limits, parseResourceList(nestedField(containerMap, "resources", "limits")))
+	}
+	overhead := parseResourceList(nestedField(object, "spec", "overhead"))
+	addResources(requests, overhead)
+	addResources(limits, overhead)
+	return requests, limits
+}
+
+// parseResourceList parses a resource list such as {"cpu": "100m", "memory": "1Gi"}.
+// Values that are not valid quantities are ignored.
+// It takes the result of nestedField so that missing lists can be passed directly.
+func parseResourceList(value interface{}, found bool) map[string]resource.Quantity {
+	resourceList := make(map[string]resource.Quantity)
+	valueMap, ok := value.(map[string]interface{})
+	if !found || !ok {
+		return resourceList
+	}
+	for resourceName, elem := range valueMap {
+		var quantity resource.Quantity
+		var err error
+		switch elemValue := elem.(type) {
+		case string:
+			quantity, err = resource.ParseQuantity(elemValue)
+		case float64:
+			quantity = *resource.NewMilliQuantity(int64(elemValue*1000), resource.DecimalSI)
+		default:
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		resourceList[resourceName] = quantity
+	}
+	return resourceList
+}
+
+func addResources(total, resources map[string]resource.Quantity) {
+	for resourceName, quantity := range resources {
+		sum := total[resourceName]
+		sum.Add(quantity)
+		total[resourceName] = sum
+	}
+}
+
+func maxResources(total, resources map[string]resource.Quantity) {
+	for resourceName, quantity := range resources {
+		if current, present := total[resourceName]; !present || quantity.Cmp(current) > 0 {
+			total[resourceName] = quantity
+		}
+	}
+}
+
+// rollUpResources sets the Requests and Limits of the composition and all of its
+// descendants to the totals of their subtrees.
+func rollUpResources(composition *Composition) (map[string]resource.Quantity, map[string]resource.Quantity) {
+	requests := make(map[string]resource.Quantity)
+	limits := make(map[string]resource.Quantity)
+	addResources(requests, composition.requests)
+	addResources(limits, composition.limits)
+	for i := range composition.Children {
+		childRequests, childLimits := rollUpResources(&composition.Children[i])
+		addResources(requests, childRequests)
+		addResources(limits, childLimits)
+	}
+	composition.Requests = formatResources(requests)
+	composition.Limits = formatResources(limits)
+	return requests, limits
+}
+
+func formatResources(resources map[string]resource.Quantity) map[string]string {
+	if len(resources) == 0 {
+		return nil
+	}
+	formatted := make(map[string]string, len(resources))
+	for resourceName, quantity := range resources {
+		formatted[resourceName] = quantity.String()
+	}
+	return formatted
+}
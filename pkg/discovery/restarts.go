@@ -0,0 +1,75 @@
+package discovery
+
+import (
+	"fmt"
+	"time"
+)
+
+// Used to store the restart diagnostics of the containers of a Pod
+type ContainerDiagnostics struct {
+	Restarts              int64
+	LastTerminationReason string
+	WaitingReasons        []string
+}
+
+// parseContainerDiagnostics returns the total restart count of the containers of the Pod,
+// the reason of the most recent container termination and the reasons of the containers
+// that are waiting (e.g. CrashLoopBackOff or ImagePullBackOff).
+func parseContainerDiagnostics(object map[string]interface{}) ContainerDiagnostics {
+	diagnostics := ContainerDiagnostics{}
+	var lastFinishedAt time.Time
+	for _, statusField := range []string{"initContainerStatuses", "containerStatuses"} {
+		for _, containerStatus := range nestedSlice(object, "status", statusField) {
+			containerStatusMap, ok := containerStatus.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			containerName := nestedString(containerStatusMap, "name")
+			diagnostics.Restarts = diagnostics.Restarts + nestedNumber(containerStatusMap, 0, "restartCount")
+
+			if waitingReason := nestedString(containerStatusMap, "state", "waiting", "reason"); waitingReason != "" &&
+				waitingReason != "PodInitializing" && waitingReason != "ContainerCreating" {
+				diagnostics.WaitingReasons = append(diagnostics.WaitingReasons,
+					fmt.Sprintf("container %s: %s", containerName, waitingReason))
+			}
+
+			terminated, found := nestedField(containerStatusMap, "lastState", "terminated")
+			terminatedMap, ok := terminated.(map[string]interface{})
+			if !found || !ok {
+				continue
+			}
+			finishedAt, _ := time.Parse(time.RFC3339, nestedString(terminatedMap, "finishedAt"))
+			if diagnostics.LastTerminationReason != "" && !finishedAt.After(lastFinishedAt) {
+				continue
+			}
+			lastFinishedAt = finishedAt
+			reason := nestedString(terminatedMap, "reason")
+			if reason == "" {
+				reason = "Terminated"
+			}
+			diagnostics.LastTerminationReason = fmt.Sprintf("container %s: %s (exit code %d)",
+				containerName, reason, nestedNumber(terminatedMap, 0, "exitCode"))
+			if !finishedAt.IsZero() {
+				diagnostics.LastTerminationReason = diagnostics.LastTerminationReason + " at " + formatTimestamp(finishedAt)
+			}
+		}
+	}
+	return diagnostics
+}
+
+// rollUpRestarts sets the aggregated restart count and waiting reasons of the composition
+// and all of its descendants. The waiting reasons are prefixed with the Pod they belong to.
+func rollUpRestarts(composition *Composition) {
+	composition.AggregatedRestarts = composition.Restarts
+	composition.AggregatedWaitingReasons = nil
+	for _, waitingReason := range composition.WaitingReasons {
+		composition.AggregatedWaitingReasons = append(composition.AggregatedWaitingReasons,
+			composition.Kind+" "+composition.Name+": "+waitingReason)
+	}
+	for i := range composition.Children {
+		child := &composition.Children[i]
+		rollUpRestarts(child)
+		composition.AggregatedRestarts = composition.AggregatedRestarts + child.AggregatedRestarts
+		composition.AggregatedWaitingReasons = append(composition.AggregatedWaitingReasons, child.AggregatedWaitingReasons...)
+	}
+}
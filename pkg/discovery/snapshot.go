@@ -0,0 +1,107 @@
+package discovery
+
+import (
+	"fmt"
+)
+
+// Used to hold the resources queried from the main API server during a single
+// build cycle. Each Kind is listed at most once per namespace; all the composition
+// trees of the cycle are then assembled from this in-memory copy.
+type clusterSnapshot struct {
+	resources map[string][]MetaDataAndOwnerReferences
+	// Resources indexed by the name of their owner
+	children map[string]map[string][]MetaDataAndOwnerReferences
+	errors   map[string]error
+	// Endpoints of the Services, listed only if Services are part of the trees
+	serviceEndpoints map[string]ServiceEndpoints
+	endpointsErr     error
+	endpointsListed  bool
+}
+
+func newClusterSnapshot() *clusterSnapshot {
+	return &clusterSnapshot{
+		resources: make(map[string][]MetaDataAndOwnerReferences),
+		children:  make(map[string]map[string][]MetaDataAndOwnerReferences),
+		errors:    make(map[string]error),
+	}
+}
+
+// getResources returns all the resources of the Kind in the namespace,
+// querying the main API server only the first time they are requested in this cycle.
+func (s *clusterSnapshot) getResources(resourceKind, namespace string) ([]MetaDataAndOwnerReferences, error) {
+	key := queryErrorKey(resourceKind, namespace)
+	if err, failed := s.errors[key]; failed {
+		return nil, err
+	}
+	if resources, listed := s.resources[key]; listed {
+		return resources, nil
+	}
+
+	resources, err := getResourceNames(resourceKind, namespace)
+	if err != nil {
+		s.errors[key] = err
+		return nil, err
+	}
+	if resourceKind == SERVICE {
+		s.setServiceEndpoints(resources, namespace)
+	}
+	s.resources[key] = resources
+
+	childrenByOwner := make(map[string][]MetaDataAndOwnerReferences)
+	for _, resource := range resources {
+		ownerName := resource.OwnerReferenceName
+		if ownerName == "" {
+			continue
+		}
+		// Prevent duplicates
+		present := false
+		for _, child := range childrenByOwner[ownerName] {
+			if child.MetaDataName == resource.MetaDataName {
+				present = true
+			}
+		}
+		if !present {
+			childrenByOwner[ownerName] = append(childrenByOwner[ownerName], resource)
+		}
+	}
+	s.children[key] = childrenByOwner
+	return resources, nil
+}
+
+// getChildren returns the resources of the Kind in the namespace that are owned by parentResourceName.
+func (s *clusterSnapshot) getChildren(resourceKind, namespace, parentResourceName string) ([]MetaDataAndOwnerReferences, error) {
+	if _, err := s.getResources(resourceKind, namespace); err != nil {
+		return nil, err
+	}
+	children := s.children[queryErrorKey(resourceKind, namespace)][parentResourceName]
+	if children == nil {
+		children = []MetaDataAndOwnerReferences{}
+	}
+	return children, nil
+}
+
+// setServiceEndpoints sets the endpoints of the Services, and their health from the
+// readiness of the endpoints. If the endpoints cannot be listed, the Services are
+// left as they are.
+func (s *clusterSnapshot) setServiceEndpoints(services []MetaDataAndOwnerReferences, namespace string) {
+	if !s.endpointsListed {
+		s.serviceEndpoints, s.endpointsErr = getServiceEndpoints(namespace)
+		s.endpointsListed = true
+		if s.endpointsErr != nil {
+			fmt.Printf("Error: could not list endpoints in ns %s: %s\n", namespace, s.endpointsErr.Error())
+		}
+	}
+	if s.endpointsErr != nil {
+		return
+	}
+	for i := range services {
+		endpoints, present := s.serviceEndpoints[services[i].MetaDataName]
+		if !present {
+			continue
+		}
+		services[i].Endpoints = &endpoints
+		health := endpointsHealth(endpoints)
+		services[i].Status = health.Status
+		services[i].StatusReason = health.Reason
+	}
+}
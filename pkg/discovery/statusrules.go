@@ -0,0 +1,124 @@
+package discovery
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// Used to describe where the status of resources of a Kind lives and which of its
+// values mean Ready, Progressing or Failed. Path and Reason are JSONPath expressions
+// (as used by 'kubectl get -o jsonpath'), e.g. {.status.state} or
+// {.status.conditions[?(@.type=="Ready")].status}.
+type StatusRule struct {
+	Path        string   `yaml:"path"`
+	Reason      string   `yaml:"reason"`
+	Ready       []string `yaml:"ready"`
+	Progressing []string `yaml:"progressing"`
+	Failed      []string `yaml:"failed"`
+}
+
+// validateStatusRule checks that the expressions of the rule can be parsed.
+func validateStatusRule(rule StatusRule) error {
+	if rule.Path == "" {
+		return fmt.Errorf("status path is not set")
+	}
+	for _, expression := range []string{rule.Path, rule.Reason} {
+		if expression == "" {
+			continue
+		}
+		if err := jsonpath.New("status").Parse(jsonPathTemplate(expression)); err != nil {
+			return fmt.Errorf("invalid JSONPath %s: %s", expression, err.Error())
+		}
+	}
+	return nil
+}
+
+// Evaluate returns the health of the object according to the rule. A value that is not
+// listed as Ready, Progressing or Failed is treated as Progressing.
+func (rule StatusRule) Evaluate(object map[string]interface{}) Health {
+	value, err := evaluateJSONPath(rule.Path, object)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return Health{}
+	}
+	if value == "" {
+		return Health{}
+	}
+	reason := value
+	if rule.Reason != "" {
+		if reasonValue, err := evaluateJSONPath(rule.Reason, object); err == nil && reasonValue != "" {
+			reason = reasonValue
+		}
+	}
+	switch {
+	case contains(rule.Failed, value):
+		return Health{HEALTH_FAILED, reason}
+	case contains(rule.Progressing, value):
+		return Health{HEALTH_PROGRESSING, reason}
+	case contains(rule.Ready, value):
+		if rule.Reason == "" {
+			reason = ""
+		}
+		return Health{HEALTH_READY, reason}
+	}
+	return Health{HEALTH_PROGRESSING, reason}
+}
+
+// evaluateJSONPath returns the values selected by the expression separated by spaces.
+// Missing fields result in an empty value.
+func evaluateJSONPath(expression string, object map[string]interface{}) (string, error) {
+	// JSONPath parsers keep evaluation state, so a new one is used for every evaluation
+	j := jsonpath.New("status").AllowMissingKeys(true)
+	if err := j.Parse(jsonPathTemplate(expression)); err != nil {
+		return "", err
+	}
+	buf := new(bytes.Buffer)
+	if err := j.Execute(buf, object); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// jsonPathTemplate allows expressions to be written without the enclosing braces.
+func jsonPathTemplate(expression string) string {
+	expression = strings.TrimSpace(expression)
+	if strings.HasPrefix(expression, "{") {
+		return expression
+	}
+	return "{" + expression + "}"
+}
+
+// parseStatusRule reads a status rule given as a JSON object, e.g. in a CRD annotation
+// or a KindComposition object.
+// The lists of values can be given either as lists or as comma separated strings.
+func parseStatusRule(statusRuleMap map[string]interface{}) StatusRule {
+	rule := StatusRule{}
+	rule.Path, _ = statusRuleMap["path"].(string)
+	rule.Reason, _ = statusRuleMap["reason"].(string)
+	rule.Ready = parseValueList(statusRuleMap["ready"])
+	rule.Progressing = parseValueList(statusRuleMap["progressing"])
+	rule.Failed = parseValueList(statusRuleMap["failed"])
+	return rule
+}
+
+func parseValueList(value interface{}) []string {
+	valueList := []string{}
+	switch values := value.(type) {
+	case string:
+		for _, elem := range strings.Split(values, ",") {
+			if elem = strings.TrimSpace(elem); elem != "" {
+				valueList = append(valueList, elem)
+			}
+		}
+	case []interface{}:
+		for _, elem := range values {
+			if elemString, ok := elem.(string); ok {
+				valueList = append(valueList, elemString)
+			}
+		}
+	}
+	return valueList
+}
@@ -0,0 +1,103 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Used to report whether the composition trees are up to date with the main API server
+type SyncStatus struct {
+	Degraded        bool
+	LastGoodSync    string
+	LastGoodSyncAge string
+	Errors          []string `json:",omitempty"`
+	// Set if layers of the Kind registry could not be reloaded and their last good entries are in use
+	RegistryError string `json:",omitempty"`
+}
+
+// Used to track the outcome of the build cycles.
+// A cycle is good if all of its queries succeeded. A cycle in which no query
+// succeeded at all (e.g. the API server is unreachable) counts as a failure
+// and makes the builder back off exponentially.
+type syncTracker struct {
+	lastGoodSync time.Time
+	errors       []string
+	failures     int
+	mux          sync.Mutex
+}
+
+var (
+	buildSyncTracker = &syncTracker{}
+)
+
+const (
+	buildInterval = time.Second * 10
+)
+
+// cycleCompleted records the outcome of a build cycle and returns how long
+// the builder should wait before starting the next one.
+func (t *syncTracker) cycleCompleted(succeeded, failed int, errors []string) time.Duration {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.errors = errors
+	if len(errors) == 0 {
+		t.lastGoodSync = time.Now()
+	}
+	if failed > 0 && succeeded == 0 {
+		t.failures++
+	} else {
+		t.failures = 0
+	}
+	return t.backoff()
+}
+
+func (t *syncTracker) backoff() time.Duration {
+	delay := backoffDelay(t.failures)
+	if t.failures > 0 {
+		fmt.Printf("Error: build cycle failed %d time(s) in a row, retrying in %s\n", t.failures, delay)
+	}
+	return delay
+}
+
+// backoffDelay returns how long to wait after the given number of failures in a row:
+// buildInterval, doubled for every failure after the first, up to maxBuildBackoff.
+func backoffDelay(failures int) time.Duration {
+	delay := buildInterval
+	for i := 1; i < failures && delay < maxBuildBackoff; i++ {
+		delay = delay * 2
+	}
+	if delay > maxBuildBackoff {
+		delay = maxBuildBackoff
+	}
+	return delay
+}
+
+func (t *syncTracker) status() SyncStatus {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	syncStatus := SyncStatus{
+		Degraded: len(t.errors) > 0 || t.lastGoodSync.IsZero(),
+		Errors:   t.errors,
+	}
+	syncStatus.RegistryError = GetRegistryError()
+	if !t.lastGoodSync.IsZero() {
+		syncStatus.LastGoodSync = t.lastGoodSync.Format(time.RFC3339)
+		syncStatus.LastGoodSyncAge = syncAge(t.lastGoodSync)
+	}
+	return syncStatus
+}
+
+// GetSyncStatus returns the state of the last build cycle as JSON.
+func GetSyncStatus() (string, error) {
+	syncStatusBytes, err := json.Marshal(buildSyncTracker.status())
+	if err != nil {
+		return "", err
+	}
+	return string(syncStatusBytes), nil
+}
+
+func syncAge(syncTime time.Time) string {
+	return time.Since(syncTime).Round(time.Second).String()
+}
@@ -2,56 +2,137 @@ package discovery
 
 import (
 	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 // Used for unmarshalling JSON output from the main API server
 type composition struct {
-	Kind        string   `yaml:"kind"`
-	Plural      string   `yaml:"plural"`
-	Endpoint    string   `yaml:"endpoint"`
-	Composition []string `yaml:"composition"`
+	Kind        string              `yaml:"kind"`
+	Plural      string              `yaml:"plural"`
+	Endpoint    string              `yaml:"endpoint"`
+	Composition []string            `yaml:"composition"`
+	OpenAPISpec OpenAPISpecLocation `yaml:"openapispec"`
+	// Only list the metadata of resources of this Kind; their status is not needed
+	MetadataOnly bool `yaml:"metadataOnly"`
+	// Where the status of resources of this Kind lives, if not in the standard fields
+	Status StatusRule `yaml:"status"`
+}
+
+// Used to locate the OpenAPI Spec of a Kind. Empty fields are defaulted
+// when the Spec is looked up (see GetOpenAPISpecLocation).
+type OpenAPISpecLocation struct {
+	Namespace        string `yaml:"namespace"`
+	ConfigMap        string `yaml:"configmap"`
+	Key              string `yaml:"key"`
+	DefinitionPrefix string `yaml:"definitionPrefix"`
 }
 
 // Used for Final output
 type Composition struct {
-	Level     int
-	Kind      string
-	Name      string
-	Namespace string
-	Status    string
-	Children  []Composition
+	Level             int
+	Kind              string
+	Name              string
+	Namespace         string
+	UID               string            `json:",omitempty"`
+	APIVersion        string            `json:",omitempty"`
+	ResourceVersion   string            `json:",omitempty"`
+	CreationTimestamp string            `json:",omitempty"`
+	Age               string            `json:",omitempty"`
+	DeletionTimestamp string            `json:",omitempty"`
+	Labels            map[string]string `json:",omitempty"`
+	Annotations       map[string]string `json:",omitempty"`
+	Status            string
+	// Why the resource is not Ready (or how it completed)
+	StatusReason string `json:",omitempty"`
+	// Worst health of the resource and all its descendants, and which of them is responsible
+	AggregatedStatus       string
+	AggregatedStatusReason string `json:",omitempty"`
+	// Readiness of the endpoints of a Service
+	Endpoints *ServiceEndpoints `json:",omitempty"`
+	// Container restarts of a Pod, and the totals of the resource and all its descendants
+	Restarts                 int64    `json:",omitempty"`
+	LastTerminationReason    string   `json:",omitempty"`
+	WaitingReasons           []string `json:",omitempty"`
+	AggregatedRestarts       int64    `json:",omitempty"`
+	AggregatedWaitingReasons []string `json:",omitempty"`
+	// Total requests and limits of the resource and all its descendants
+	Requests map[string]string `json:",omitempty"`
+	Limits   map[string]string `json:",omitempty"`
+	requests map[string]resource.Quantity
+	limits   map[string]resource.Quantity
+	// Images of the containers of Pods, returned by the images endpoint
+	containers []ContainerImage
+	// Recent Events of the resource; only set if requested
+	Events   []Event `json:",omitempty"`
+	Children []Composition
+	Warnings []string `json:",omitempty"`
+	Redacted bool     `json:",omitempty"`
+	// Set if the tree could not be refreshed and is the one built in the last good sync
+	Stale       bool   `json:",omitempty"`
+	LastSyncAge string `json:",omitempty"`
 }
 
 // Used to store information queried from the main API server
 type MetaDataAndOwnerReferences struct {
 	MetaDataName             string
 	Status                   string
+	StatusReason             string
 	Namespace                string
 	OwnerReferenceName       string
 	OwnerReferenceKind       string
 	OwnerReferenceAPIVersion string
+	UID                      string
+	APIVersion               string
+	ResourceVersion          string
+	CreationTimestamp        string
+	DeletionTimestamp        string
+	Labels                   map[string]string
+	Annotations              map[string]string
+	// Resources reserved by the resource itself (Pods and PersistentVolumeClaims)
+	Requests map[string]resource.Quantity
+	Limits   map[string]resource.Quantity
+	// Images of the containers of Pods
+	Containers []ContainerImage
+	// Restarts and waiting reasons of the containers of Pods
+	Diagnostics ContainerDiagnostics
+	// Readiness of the endpoints of Services
+	Endpoints *ServiceEndpoints
 }
 
 // Used for intermediate storage -- probably can be combined/merged with
 // type Provenance and/or type Composition
+// Error is set if the children of ChildKind could not be queried.
 type CompositionTreeNode struct {
 	Level     int
 	ChildKind string
 	Children  []MetaDataAndOwnerReferences
+	Error     string
 }
 
 // Used for intermediate storage -- probably can be merged with Composition
 type Compositions struct {
-	Kind            string
-	Name            string
-	Namespace       string
-	Status          string
+	Kind         string
+	Name         string
+	Namespace    string
+	Status       string
+	StatusReason string
+	// Metadata of the top-level resource
+	MetaData        MetaDataAndOwnerReferences
 	CompositionTree *[]CompositionTreeNode
+	Warnings        []string
+	// Time at which the tree was last built without errors, zero if never
+	LastSynced time.Time
+	Stale      bool
 }
 
 // Used to hold entire composition Provenance of all the Kinds
+// queryErrors records, per namespace and Kind, the top-level queries that
+// failed in the last build cycle.
 type ClusterCompositions struct {
 	clusterCompositions []Compositions
+	queryErrors         map[string]map[string]string
 	mux                 sync.Mutex
 }
 
@@ -120,15 +120,15 @@ func (cp *ClusterCompositions) PrintCompositions() {
 
 func printMaps() {
 	fmt.Println("Printing kindVersionMap")
-	for key, value := range kindVersionMap {
+	for key, value := range getRegistry().versionMap {
 		fmt.Printf("%s, %s\n", key, value)
 	}
 	fmt.Println("Printing KindPluralMap")
-	for key, value := range KindPluralMap {
+	for key, value := range getRegistry().pluralMap {
 		fmt.Printf("%s, %s\n", key, value)
 	}
 	fmt.Println("Printing compositionMap")
-	for key, value := range compositionMap {
+	for key, value := range getRegistry().compositionMap {
 		fmt.Printf("%s, %s\n", key, value)
 	}
 }